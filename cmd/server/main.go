@@ -2,63 +2,271 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"runtime/debug"
+	"syscall"
 	"time"
 
+	"github.com/openmcp-project/ui-backend/internal/config"
 	"github.com/openmcp-project/ui-backend/internal/utils"
 	"github.com/openmcp-project/ui-backend/pkg/k8s"
-	"k8s.io/client-go/tools/clientcmd"
+	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/openmcp-project/ui-backend/internal/server"
 )
 
+// version is set at build time with -ldflags "-X main.version=v1.2.3"
+var version string
+
 func main() {
-	ctx := context.Background()
+	cfg, options, err := config.Load(os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if options.PrintConfig {
+		if err := config.Print(os.Stdout, cfg); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	// the exit code is set when the server can't be started, it is returned after the deferred cleanup ran
+	exitCode := 0
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
 
-	kubeconfigPath := os.Getenv(clientcmd.RecommendedConfigPathEnvVar)
-	if kubeconfigPath == "" {
-		slog.Error("env variable '%s' with kubeconfig path not set", clientcmd.RecommendedConfigPathEnvVar)
+	// the context is canceled on SIGTERM or SIGINT, which starts the shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: cfg.SlogLevel()})))
+	if options.File != "" {
+		slog.Info("loaded config", "file", options.File)
+	}
+
+	watcherDone := make(chan struct{})
+	go func() {
+		defer close(watcherDone)
+		utils.StartListeningOnKubeconfig(ctx, cfg.Kubeconfig)
+	}()
+
+	shutdownTracing, err := setupTracing(ctx)
+	if err != nil {
+		slog.Error("failed to set up tracing", "err", err)
+		exitCode = 1
 		return
 	}
-	go utils.StartListeningOnKubeconfig(ctx, kubeconfigPath)
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			slog.Error("failed to flush traces", "err", err)
+		}
+	}()
 
-	cachingKube := k8s.NewCachingKube(k8s.HttpKube{}, time.Second*30, time.Minute)
-	downstreamKube := k8s.HttpKube{}
+	// transient errors of the api servers are retried instead of being cached
+	upstreamKube := k8s.NewRetryingKube(k8s.HttpKube{
+		Timeout: cfg.Upstream.Timeout.Duration,
+	}, k8s.RetryPolicy{
+		MaxAttempts:    cfg.Upstream.RetryAttempts,
+		InitialBackoff: cfg.Upstream.RetryInitialBackoff.Duration,
+		MaxBackoff:     cfg.Upstream.RetryMaxBackoff.Duration,
+	})
+
+	// the crate is only used to look up MCPs and their kubeconfig secrets
+	cachingKube := k8s.NewCachingKube(upstreamKube, k8s.CachePolicy{
+		MaxBytes:        cfg.Cache.MaxBytes / 2,
+		MaxEntryBytes:   cfg.Cache.MaxEntryBytes,
+		DiscoveryTTL:    cfg.Cache.DiscoveryTTL.Duration,
+		ControlPlaneTTL: cfg.Cache.ControlPlaneTTL.Duration,
+		SecretTTL:       cfg.Cache.SecretTTL.Duration,
+		DefaultTTL:      cfg.Cache.DefaultTTL.Duration,
+		ErrorTTL:        cfg.Cache.ErrorTTL.Duration,
+		ServerErrorTTL:  cfg.Cache.ServerErrorTTL.Duration,
+		NotFoundTTL:     cfg.Cache.NotFoundTTL.Duration,
+	})
+	// requests proxied to the clusters are passed through, only discovery is cached
+	downstreamKube := k8s.NewCachingKube(upstreamKube, k8s.CachePolicy{
+		MaxBytes:       cfg.Cache.MaxBytes / 2,
+		MaxEntryBytes:  cfg.Cache.MaxEntryBytes,
+		DiscoveryTTL:   cfg.Cache.DiscoveryTTL.Duration,
+		ErrorTTL:       cfg.Cache.ErrorTTL.Duration,
+		ServerErrorTTL: cfg.Cache.ServerErrorTTL.Duration,
+		NotFoundTTL:    cfg.Cache.NotFoundTTL.Duration,
+	})
 
 	jqConfig := server.JQConfig{
-		MaxExpressionLength: getEnvInt("JQ_MAX_EXPRESSION_LENGTH", 500),
-		ExecutionTimeout:    getEnvDuration("JQ_EXECUTION_TIMEOUT", 5*time.Second),
-		MaxResults:          getEnvInt("JQ_MAX_RESULTS", 10000),
+		MaxExpressionLength: cfg.JQ.MaxExpressionLength,
+		ExecutionTimeout:    cfg.JQ.ExecutionTimeout.Duration,
+		MaxResults:          cfg.JQ.MaxResults,
+	}
+
+	jsonPathConfig := server.JsonPathConfig{
+		MaxExpressionLength: cfg.JsonPath.MaxExpressionLength,
+		ExecutionTimeout:    cfg.JsonPath.ExecutionTimeout.Duration,
+		MaxOutputSize:       cfg.JsonPath.MaxOutputSize,
+	}
+
+	categoryConfig := server.CategoryConfig{
+		MaxConcurrency:  cfg.Category.MaxConcurrency,
+		ResourceTimeout: cfg.Category.ResourceTimeout.Duration,
+	}
+
+	compressionConfig := server.CompressionConfig{
+		MinSize:      cfg.Compression.MinSize,
+		UpstreamGzip: cfg.Compression.UpstreamGzip,
+	}
+
+	corsConfig := server.CORSConfig{
+		AllowedOrigins:   cfg.CORS.AllowedOrigins,
+		AllowedMethods:   cfg.CORS.AllowedMethods,
+		AllowedHeaders:   cfg.CORS.AllowedHeaders,
+		ExposedHeaders:   cfg.CORS.ExposedHeaders,
+		MaxAge:           cfg.CORS.MaxAge.Duration,
+		AllowCredentials: cfg.CORS.AllowCredentials,
+	}
+
+	var informers *k8s.InformerCache
+	if len(cfg.Informers.Resources) > 0 {
+		informers = k8s.NewInformerCache(upstreamKube, k8s.InformerPolicy{
+			Resources:       cfg.Informers.Resources,
+			IdleTimeout:     cfg.Informers.IdleTimeout.Duration,
+			SyncTimeout:     cfg.Informers.SyncTimeout.Duration,
+			AccessReviewTTL: cfg.Informers.AccessReviewTTL.Duration,
+		})
 	}
 
-	mux := server.NewMiddleware(cachingKube, downstreamKube, jqConfig)
+	caches := map[string]k8s.CacheAdmin{
+		"crate":      cachingKube.(k8s.CacheAdmin),
+		"downstream": downstreamKube.(k8s.CacheAdmin),
+	}
+
+	// the readiness probe bypasses the cache, so it sees whether the crate is reachable right now
+	health := server.NewHealth(server.CrateProbe(upstreamKube))
+	mux := server.NewMiddleware(k8s.NewTracingKube(cachingKube), k8s.NewTracingKube(downstreamKube), server.Config{
+		JQ:          jqConfig,
+		JsonPath:    jsonPathConfig,
+		Category:    categoryConfig,
+		Compression: compressionConfig,
+		CORS:        corsConfig,
+		Informers:   informers,
+		Health:      health,
+	})
+	prometheus.MustRegister(k8s.NewCacheCollector(map[string]k8s.CacheStatsProvider{
+		"crate":      caches["crate"],
+		"downstream": caches["downstream"],
+	}))
 
-	address := ":3000"
-	slog.Info("Starting server", "address", address)
-	if err := http.ListenAndServe(address, mux); err != nil {
+	var handler http.Handler = mux
+	var tlsConfig *tls.Config
+	if cfg.TLS.CertFile != "" {
+		certificate, err := utils.NewWatchedCertificate(cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.TLS.ClientCAFile)
+		if err != nil {
+			slog.Error("failed to set up TLS", "err", err)
+			exitCode = 1
+			return
+		}
+		go func() {
+			if err := certificate.Watch(ctx); err != nil {
+				slog.Error("certificate won't be reloaded", "err", err)
+			}
+		}()
+		tlsConfig = certificate.TLSConfig()
+		if cfg.TLS.ClientCAFile != "" {
+			handler = server.RequireClientCertificate(handler)
+		}
+	}
+
+	// streamed responses (watches, followed logs and upgraded connections) are exempt from the read and write timeouts
+	servers := []*http.Server{{
+		Addr:              cfg.Server.ListenAddress,
+		Handler:           handler,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout.Duration,
+		ReadTimeout:       cfg.Server.ReadTimeout.Duration,
+		WriteTimeout:      cfg.Server.WriteTimeout.Duration,
+		IdleTimeout:       cfg.Server.IdleTimeout.Duration,
+	}}
+	// the admin endpoints are disabled unless an address is configured, they must not be exposed publicly
+	if cfg.Admin.Address != "" {
+		servers = append(servers, &http.Server{
+			Addr:              cfg.Admin.Address,
+			Handler:           server.NewAdminMux(caches, buildVersion()),
+			ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout.Duration,
+		})
+	}
+
+	serverErrors := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func() {
+			slog.Info("Starting server", "address", srv.Addr, "tls", srv.TLSConfig != nil)
+			var err error
+			if srv.TLSConfig != nil {
+				// the certificate is taken from the TLS config
+				err = srv.ListenAndServeTLS("", "")
+			} else {
+				err = srv.ListenAndServe()
+			}
+			if !errors.Is(err, http.ErrServerClosed) {
+				serverErrors <- fmt.Errorf("server at %s failed: %w", srv.Addr, err)
+			}
+		}()
+	}
+
+	select {
+	case <-ctx.Done():
+		slog.Info("shutting down")
+		// readiness fails first, so no new requests are sent to this instance while it drains the open ones
+		health.Drain()
+		time.Sleep(cfg.Server.ShutdownReadinessDelay.Duration)
+	case err := <-serverErrors:
 		slog.Error("failed to start server", "err", err)
+		exitCode = 1
+		stop()
 	}
-}
 
-func getEnvInt(key string, defaultVal int) int {
-	if v := os.Getenv(key); v != "" {
-		if i, err := strconv.Atoi(v); err == nil {
-			return i
+	health.CloseStreams()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownGracePeriod.Duration)
+	defer cancel()
+	for _, srv := range servers {
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			// the grace period is over, so the remaining connections are closed
+			slog.Warn("closing open connections after grace period", "address", srv.Addr, "err", err)
+			_ = srv.Close()
 		}
 	}
-	return defaultVal
+
+	if informers != nil {
+		informers.Stop()
+	}
+	<-watcherDone
+	slog.Info("server stopped")
 }
 
-func getEnvDuration(key string, defaultVal time.Duration) time.Duration {
-	if v := os.Getenv(key); v != "" {
-		if d, err := time.ParseDuration(v); err == nil {
-			return d
+// buildVersion returns the version set at build time, or else the commit the binary was built from.
+func buildVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				return setting.Value
+			}
 		}
 	}
-	return defaultVal
+	return "dev"
 }
@@ -0,0 +1,283 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config contains all settings of the server. They are read from the config file, then overridden by the env
+// variables and at last by the flags.
+type Config struct {
+	// Kubeconfig is the path of the kubeconfig of the crate
+	Kubeconfig string `yaml:"kubeconfig"`
+	// LogLevel is one of debug, info, warn or error
+	LogLevel    string      `yaml:"logLevel"`
+	Server      Server      `yaml:"server"`
+	TLS         TLS         `yaml:"tls"`
+	Admin       Admin       `yaml:"admin"`
+	Upstream    Upstream    `yaml:"upstream"`
+	Cache       Cache       `yaml:"cache"`
+	JQ          JQ          `yaml:"jq"`
+	JsonPath    JsonPath    `yaml:"jsonPath"`
+	Category    Category    `yaml:"category"`
+	Compression Compression `yaml:"compression"`
+	CORS        CORS        `yaml:"cors"`
+	Informers   Informers   `yaml:"informers"`
+}
+
+type Server struct {
+	ListenAddress          string   `yaml:"listenAddress"`
+	ReadHeaderTimeout      Duration `yaml:"readHeaderTimeout"`
+	ReadTimeout            Duration `yaml:"readTimeout"`
+	WriteTimeout           Duration `yaml:"writeTimeout"`
+	IdleTimeout            Duration `yaml:"idleTimeout"`
+	ShutdownReadinessDelay Duration `yaml:"shutdownReadinessDelay"`
+	ShutdownGracePeriod    Duration `yaml:"shutdownGracePeriod"`
+}
+
+type TLS struct {
+	CertFile     string `yaml:"certFile"`
+	KeyFile      string `yaml:"keyFile"`
+	ClientCAFile string `yaml:"clientCAFile"`
+}
+
+type Admin struct {
+	// Address of the admin endpoints, they are disabled if it is empty
+	Address string `yaml:"address"`
+}
+
+type Upstream struct {
+	Timeout             Duration `yaml:"timeout"`
+	RetryAttempts       int      `yaml:"retryAttempts"`
+	RetryInitialBackoff Duration `yaml:"retryInitialBackoff"`
+	RetryMaxBackoff     Duration `yaml:"retryMaxBackoff"`
+}
+
+type Cache struct {
+	// MaxBytes is split evenly between the caches of the crate and the downstream clusters
+	MaxBytes        int64    `yaml:"maxBytes"`
+	MaxEntryBytes   int64    `yaml:"maxEntryBytes"`
+	DiscoveryTTL    Duration `yaml:"discoveryTTL"`
+	ControlPlaneTTL Duration `yaml:"controlPlaneTTL"`
+	SecretTTL       Duration `yaml:"secretTTL"`
+	DefaultTTL      Duration `yaml:"defaultTTL"`
+	ErrorTTL        Duration `yaml:"errorTTL"`
+	ServerErrorTTL  Duration `yaml:"serverErrorTTL"`
+	NotFoundTTL     Duration `yaml:"notFoundTTL"`
+}
+
+type JQ struct {
+	MaxExpressionLength int      `yaml:"maxExpressionLength"`
+	ExecutionTimeout    Duration `yaml:"executionTimeout"`
+	MaxResults          int      `yaml:"maxResults"`
+}
+
+type JsonPath struct {
+	MaxExpressionLength int      `yaml:"maxExpressionLength"`
+	ExecutionTimeout    Duration `yaml:"executionTimeout"`
+	MaxOutputSize       int      `yaml:"maxOutputSize"`
+}
+
+type Category struct {
+	MaxConcurrency  int      `yaml:"maxConcurrency"`
+	ResourceTimeout Duration `yaml:"resourceTimeout"`
+}
+
+type Compression struct {
+	MinSize      int  `yaml:"minSize"`
+	UpstreamGzip bool `yaml:"upstreamGzip"`
+}
+
+type CORS struct {
+	// AllowedOrigins are origins like https://ui.example.com or patterns like https://*.example.com, * allows all
+	AllowedOrigins   []string `yaml:"allowedOrigins"`
+	AllowedMethods   []string `yaml:"allowedMethods"`
+	AllowedHeaders   []string `yaml:"allowedHeaders"`
+	ExposedHeaders   []string `yaml:"exposedHeaders"`
+	MaxAge           Duration `yaml:"maxAge"`
+	AllowCredentials bool     `yaml:"allowCredentials"`
+}
+
+type Informers struct {
+	// Resources are patterns in the form resource.group, informers are disabled if there are none
+	Resources       []string `yaml:"resources"`
+	IdleTimeout     Duration `yaml:"idleTimeout"`
+	SyncTimeout     Duration `yaml:"syncTimeout"`
+	AccessReviewTTL Duration `yaml:"accessReviewTTL"`
+}
+
+// Default returns the config which is used for everything that isn't set.
+func Default() Config {
+	return Config{
+		LogLevel: "info",
+		Server: Server{
+			ListenAddress:          ":3000",
+			ReadHeaderTimeout:      Duration{10 * time.Second},
+			ReadTimeout:            Duration{30 * time.Second},
+			WriteTimeout:           Duration{2 * time.Minute},
+			IdleTimeout:            Duration{2 * time.Minute},
+			ShutdownReadinessDelay: Duration{5 * time.Second},
+			ShutdownGracePeriod:    Duration{30 * time.Second},
+		},
+		Upstream: Upstream{
+			Timeout:             Duration{30 * time.Second},
+			RetryAttempts:       3,
+			RetryInitialBackoff: Duration{100 * time.Millisecond},
+			RetryMaxBackoff:     Duration{time.Second},
+		},
+		Cache: Cache{
+			MaxBytes:        256 * 1024 * 1024,
+			MaxEntryBytes:   4 * 1024 * 1024,
+			DiscoveryTTL:    Duration{5 * time.Minute},
+			ControlPlaneTTL: Duration{10 * time.Second},
+			SecretTTL:       Duration{30 * time.Second},
+			DefaultTTL:      Duration{30 * time.Second},
+		},
+		JQ: JQ{
+			MaxExpressionLength: 500,
+			ExecutionTimeout:    Duration{5 * time.Second},
+			MaxResults:          10000,
+		},
+		JsonPath: JsonPath{
+			MaxExpressionLength: 500,
+			ExecutionTimeout:    Duration{5 * time.Second},
+			MaxOutputSize:       10 * 1024 * 1024,
+		},
+		Category: Category{
+			MaxConcurrency:  10,
+			ResourceTimeout: Duration{30 * time.Second},
+		},
+		Compression: Compression{
+			MinSize: 1024,
+		},
+		CORS: CORS{
+			// every origin is allowed, but without credentials, deployments with a known ui should list its origin
+			AllowedOrigins: []string{"*"},
+			AllowedMethods: []string{"*"},
+			AllowedHeaders: []string{"*"},
+			ExposedHeaders: []string{"X-Response-From-Controlplane", "X-Partial-Response"},
+		},
+		Informers: Informers{
+			IdleTimeout:     Duration{10 * time.Minute},
+			SyncTimeout:     Duration{5 * time.Second},
+			AccessReviewTTL: Duration{30 * time.Second},
+		},
+	}
+}
+
+// SlogLevel returns the log level, it must have been validated.
+func (c Config) SlogLevel() slog.Level {
+	var level slog.Level
+	_ = level.UnmarshalText([]byte(c.LogLevel))
+	return level
+}
+
+// Validate returns all invalid settings at once, so they can be fixed together.
+func (c Config) Validate() error {
+	var errs []error
+	check := func(valid bool, format string, a ...any) {
+		if !valid {
+			errs = append(errs, fmt.Errorf(format, a...))
+		}
+	}
+
+	check(c.Kubeconfig != "", "kubeconfig must be set")
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.LogLevel)) == nil, "logLevel must be one of debug, info, warn or error, got %q", c.LogLevel)
+	check(c.Server.ListenAddress != "", "server.listenAddress must be set")
+
+	check(c.TLS.CertFile == "" || c.TLS.KeyFile != "", "tls.keyFile must be set together with tls.certFile")
+	check(c.TLS.KeyFile == "" || c.TLS.CertFile != "", "tls.certFile must be set together with tls.keyFile")
+	check(c.TLS.ClientCAFile == "" || c.TLS.CertFile != "", "tls.clientCAFile requires tls.certFile and tls.keyFile")
+	check(c.Admin.Address == "" || c.Admin.Address != c.Server.ListenAddress, "admin.address must differ from server.listenAddress")
+
+	check(c.Upstream.RetryAttempts >= 1, "upstream.retryAttempts must be at least 1, got %d", c.Upstream.RetryAttempts)
+	check(c.Upstream.RetryInitialBackoff.Duration <= c.Upstream.RetryMaxBackoff.Duration,
+		"upstream.retryInitialBackoff must not exceed upstream.retryMaxBackoff, got %s and %s", c.Upstream.RetryInitialBackoff, c.Upstream.RetryMaxBackoff)
+
+	check(c.Cache.MaxBytes >= 0, "cache.maxBytes must not be negative, got %d", c.Cache.MaxBytes)
+	check(c.Cache.MaxEntryBytes >= 0, "cache.maxEntryBytes must not be negative, got %d", c.Cache.MaxEntryBytes)
+
+	check(c.JQ.MaxExpressionLength > 0, "jq.maxExpressionLength must be positive, got %d", c.JQ.MaxExpressionLength)
+	check(c.JQ.MaxResults > 0, "jq.maxResults must be positive, got %d", c.JQ.MaxResults)
+	check(c.JQ.ExecutionTimeout.Duration > 0, "jq.executionTimeout must be positive, got %s", c.JQ.ExecutionTimeout)
+	check(c.JsonPath.MaxExpressionLength > 0, "jsonPath.maxExpressionLength must be positive, got %d", c.JsonPath.MaxExpressionLength)
+	check(c.JsonPath.MaxOutputSize > 0, "jsonPath.maxOutputSize must be positive, got %d", c.JsonPath.MaxOutputSize)
+	check(c.JsonPath.ExecutionTimeout.Duration > 0, "jsonPath.executionTimeout must be positive, got %s", c.JsonPath.ExecutionTimeout)
+	check(c.Category.MaxConcurrency > 0, "category.maxConcurrency must be positive, got %d", c.Category.MaxConcurrency)
+	check(c.Category.ResourceTimeout.Duration > 0, "category.resourceTimeout must be positive, got %s", c.Category.ResourceTimeout)
+	check(c.Compression.MinSize >= 0, "compression.minSize must not be negative, got %d", c.Compression.MinSize)
+
+	for _, origin := range c.CORS.AllowedOrigins {
+		_, err := path.Match(origin, "")
+		check(origin == "*" || (strings.Contains(origin, "://") && !strings.HasSuffix(origin, "/") && err == nil),
+			"cors.allowedOrigins must contain * or origins like https://ui.example.com, got %q", origin)
+		check(origin == "*" || !wildcardInSite(origin), "cors.allowedOrigins must not contain wildcards in the last two labels of the domain, got %q", origin)
+	}
+	check(!c.CORS.AllowCredentials || !slices.Contains(c.CORS.AllowedOrigins, "*"), "cors.allowCredentials can't be combined with allowing all origins")
+	for _, method := range c.CORS.AllowedMethods {
+		check(method == strings.ToUpper(method), "cors.allowedMethods must be upper case, got %q", method)
+	}
+
+	for _, pattern := range c.Informers.Resources {
+		_, err := path.Match(pattern, "")
+		check(err == nil, "informers.resources contains the invalid pattern %q", pattern)
+	}
+
+	// a negative duration is always a typo, e.g. a missing unit is caught by the parser already
+	for _, s := range settings(&c) {
+		if d, ok := s.value.(*Duration); ok {
+			check(d.Duration >= 0, "%s must not be negative, got %s", s.name, d)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// wildcardInSite returns whether a pattern of an origin has a wildcard in the last two labels of its domain, like
+// https://*.com or https://ui.example.*, so it would match origins of any site.
+func wildcardInSite(origin string) bool {
+	_, host, _ := strings.Cut(origin, "://")
+	host, _, _ = strings.Cut(host, ":")
+	labels := strings.Split(host, ".")
+	for _, label := range labels[max(len(labels)-2, 0):] {
+		if strings.ContainsAny(label, `*?[\`) {
+			return true
+		}
+	}
+	return len(labels) < 2
+}
+
+// Duration is a time.Duration which is written as string, e.g. 30s, instead of nanoseconds.
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalYAML() (any, error) {
+	return d.String(), nil
+}
+
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	var s string
+	if err := node.Decode(&s); err != nil {
+		return err
+	}
+	return d.Set(s)
+}
+
+// Set parses the duration, it implements flag.Value.
+func (d *Duration) Set(s string) error {
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
@@ -0,0 +1,123 @@
+package config
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name  string
+		file  string
+		env   map[string]string
+		args  []string
+		check func(t *testing.T, config Config)
+		err   string
+	}{
+		{
+			name: "defaults",
+			args: []string{"--kubeconfig", "/kubeconfig"},
+			check: func(t *testing.T, config Config) {
+				expected := Default()
+				expected.Kubeconfig = "/kubeconfig"
+				if config.Server != expected.Server || config.JQ != expected.JQ || config.Cache != expected.Cache {
+					t.Errorf("expected the defaults but got %+v", config)
+				}
+			},
+		},
+		{
+			name: "flags override env override file",
+			file: "kubeconfig: /file\njq:\n  maxResults: 1\n  maxExpressionLength: 1\n  executionTimeout: 1s\n",
+			env:  map[string]string{"JQ_MAX_RESULTS": "2", "JQ_MAX_EXPRESSION_LENGTH": "2"},
+			args: []string{"--jq-max-results", "3", "--compression-upstream-gzip"},
+			check: func(t *testing.T, config Config) {
+				if config.Kubeconfig != "/file" {
+					t.Errorf("expected kubeconfig from the file but got %q", config.Kubeconfig)
+				}
+				if config.JQ.ExecutionTimeout.Duration != time.Second || config.JQ.MaxExpressionLength != 2 || config.JQ.MaxResults != 3 {
+					t.Errorf("expected timeout from the file, length from env and results from the flag but got %+v", config.JQ)
+				}
+				if !config.Compression.UpstreamGzip {
+					t.Errorf("expected the bool flag without value to be true")
+				}
+			},
+		},
+		{
+			name: "list from env",
+			env:  map[string]string{"KUBECONFIG": "/kubeconfig", "INFORMER_RESOURCES": "secrets, *.core.openmcp.cloud,"},
+			check: func(t *testing.T, config Config) {
+				if strings.Join(config.Informers.Resources, "|") != "secrets|*.core.openmcp.cloud" {
+					t.Errorf("unexpected resources %q", config.Informers.Resources)
+				}
+			},
+		},
+		{
+			name: "unknown field in file",
+			file: "kubeconfig: /file\njq:\n  executionTimout: 1s\n",
+			err:  "field executionTimout not found",
+		},
+		{
+			name: "invalid duration in file",
+			file: "kubeconfig: /file\njq:\n  executionTimeout: 5\n",
+			err:  `missing unit in duration "5"`,
+		},
+		{
+			name: "invalid env",
+			env:  map[string]string{"KUBECONFIG": "/kubeconfig", "CATEGORY_MAX_CONCURRENCY": "ten"},
+			err:  `invalid value "ten" of env CATEGORY_MAX_CONCURRENCY`,
+		},
+		{
+			name: "unknown flag",
+			args: []string{"--kubeconfig", "/kubeconfig", "--jq-max-result", "3"},
+			err:  "flag provided but not defined: -jq-max-result",
+		},
+		{
+			name: "credentials with any origin",
+			env:  map[string]string{"KUBECONFIG": "/kubeconfig", "CORS_ALLOW_CREDENTIALS": "true"},
+			err:  "cors.allowCredentials can't be combined with allowing all origins",
+		},
+		{
+			name: "origin pattern matching any site",
+			env:  map[string]string{"KUBECONFIG": "/kubeconfig", "CORS_ALLOWED_ORIGINS": "https://*.example.com,https://*,https://ui.example.*,https://*.com"},
+			err:  "cors.allowedOrigins must not contain wildcards in the last two labels of the domain, got \"https://*\"\ncors.allowedOrigins must not contain wildcards in the last two labels of the domain, got \"https://ui.example.*\"\ncors.allowedOrigins must not contain wildcards in the last two labels of the domain, got \"https://*.com\"",
+		},
+		{
+			name: "all validation errors",
+			args: []string{"--log-level", "verbose", "--tls-cert-file", "/tls.crt", "--upstream-retry-initial-backoff", "2s", "--cache-error-ttl", "-1s"},
+			err:  "kubeconfig must be set\nlogLevel must be one of debug, info, warn or error, got \"verbose\"\ntls.keyFile must be set together with tls.certFile\nupstream.retryInitialBackoff must not exceed upstream.retryMaxBackoff, got 2s and 1s\ncache.errorTTL must not be negative, got -1s",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("KUBECONFIG", "")
+			for k, v := range test.env {
+				t.Setenv(k, v)
+			}
+			args := test.args
+			if test.file != "" {
+				file := filepath.Join(t.TempDir(), "config.yaml")
+				if err := os.WriteFile(file, []byte(test.file), 0o600); err != nil {
+					t.Fatal(err)
+				}
+				args = append([]string{"--config", file}, args...)
+			}
+
+			config, _, err := Load(args, io.Discard)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing %q but got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			test.check(t, config)
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// setting binds a field of the config to its env variable and flag.
+type setting struct {
+	// name is the path of the field in the config file
+	name  string
+	env   string
+	flag  string
+	usage string
+	// value points to the field, it is a *string, *int, *int64, *bool, *[]string or *Duration
+	value any
+}
+
+// settings returns the bindings of every field of the config. The env variables are the ones used before the config
+// file was introduced.
+func settings(c *Config) []setting {
+	return []setting{
+		{"kubeconfig", "KUBECONFIG", "kubeconfig", "path of the kubeconfig of the crate", &c.Kubeconfig},
+		{"logLevel", "LOG_LEVEL", "log-level", "one of debug, info, warn or error", &c.LogLevel},
+
+		{"server.listenAddress", "LISTEN_ADDRESS", "listen-address", "address the server listens on", &c.Server.ListenAddress},
+		{"server.readHeaderTimeout", "SERVER_READ_HEADER_TIMEOUT", "server-read-header-timeout", "time to read the request headers", &c.Server.ReadHeaderTimeout},
+		{"server.readTimeout", "SERVER_READ_TIMEOUT", "server-read-timeout", "time to read the whole request", &c.Server.ReadTimeout},
+		{"server.writeTimeout", "SERVER_WRITE_TIMEOUT", "server-write-timeout", "time until the response has to be written", &c.Server.WriteTimeout},
+		{"server.idleTimeout", "SERVER_IDLE_TIMEOUT", "server-idle-timeout", "time keep-alive connections are kept open between requests", &c.Server.IdleTimeout},
+		{"server.shutdownReadinessDelay", "SHUTDOWN_READINESS_DELAY", "shutdown-readiness-delay", "time between failing the readiness check and closing the listener", &c.Server.ShutdownReadinessDelay},
+		{"server.shutdownGracePeriod", "SHUTDOWN_GRACE_PERIOD", "shutdown-grace-period", "time open requests get to complete during a shutdown", &c.Server.ShutdownGracePeriod},
+
+		{"tls.certFile", "TLS_CERT_FILE", "tls-cert-file", "certificate to serve HTTPS with, plain HTTP is served without it", &c.TLS.CertFile},
+		{"tls.keyFile", "TLS_KEY_FILE", "tls-key-file", "private key of the certificate", &c.TLS.KeyFile},
+		{"tls.clientCAFile", "TLS_CLIENT_CA_FILE", "tls-client-ca-file", "CA which client certificates are required to be signed by", &c.TLS.ClientCAFile},
+
+		{"admin.address", "ADMIN_ADDRESS", "admin-address", "address of the admin endpoints, they are disabled without it", &c.Admin.Address},
+
+		{"upstream.timeout", "UPSTREAM_TIMEOUT", "upstream-timeout", "time to wait for the response headers of an api server", &c.Upstream.Timeout},
+		{"upstream.retryAttempts", "UPSTREAM_RETRY_ATTEMPTS", "upstream-retry-attempts", "attempts of a request including the first one", &c.Upstream.RetryAttempts},
+		{"upstream.retryInitialBackoff", "UPSTREAM_RETRY_INITIAL_BACKOFF", "upstream-retry-initial-backoff", "wait time before the first retry", &c.Upstream.RetryInitialBackoff},
+		{"upstream.retryMaxBackoff", "UPSTREAM_RETRY_MAX_BACKOFF", "upstream-retry-max-backoff", "maximum wait time between two attempts", &c.Upstream.RetryMaxBackoff},
+
+		{"cache.maxBytes", "CACHE_MAX_BYTES", "cache-max-bytes", "memory budget of the caches", &c.Cache.MaxBytes},
+		{"cache.maxEntryBytes", "CACHE_MAX_ENTRY_BYTES", "cache-max-entry-bytes", "larger responses aren't cached", &c.Cache.MaxEntryBytes},
+		{"cache.discoveryTTL", "CACHE_DISCOVERY_TTL", "cache-discovery-ttl", "time discovery responses are cached", &c.Cache.DiscoveryTTL},
+		{"cache.controlPlaneTTL", "CACHE_CONTROLPLANE_TTL", "cache-controlplane-ttl", "time control planes of the crate are cached", &c.Cache.ControlPlaneTTL},
+		{"cache.secretTTL", "CACHE_SECRET_TTL", "cache-secret-ttl", "time kubeconfig secrets of the crate are cached", &c.Cache.SecretTTL},
+		{"cache.defaultTTL", "CACHE_DEFAULT_TTL", "cache-default-ttl", "time other responses of the crate are cached", &c.Cache.DefaultTTL},
+		{"cache.errorTTL", "CACHE_ERROR_TTL", "cache-error-ttl", "time 4xx responses are cached", &c.Cache.ErrorTTL},
+		{"cache.serverErrorTTL", "CACHE_SERVER_ERROR_TTL", "cache-server-error-ttl", "time 5xx responses are cached", &c.Cache.ServerErrorTTL},
+		{"cache.notFoundTTL", "CACHE_NOT_FOUND_TTL", "cache-not-found-ttl", "time 404 responses are cached", &c.Cache.NotFoundTTL},
+
+		{"jq.maxExpressionLength", "JQ_MAX_EXPRESSION_LENGTH", "jq-max-expression-length", "maximum length of a jq expression", &c.JQ.MaxExpressionLength},
+		{"jq.executionTimeout", "JQ_EXECUTION_TIMEOUT", "jq-execution-timeout", "time a jq expression may run", &c.JQ.ExecutionTimeout},
+		{"jq.maxResults", "JQ_MAX_RESULTS", "jq-max-results", "maximum number of results of a jq expression", &c.JQ.MaxResults},
+
+		{"jsonPath.maxExpressionLength", "JSONPATH_MAX_EXPRESSION_LENGTH", "jsonpath-max-expression-length", "maximum length of a jsonpath expression", &c.JsonPath.MaxExpressionLength},
+		{"jsonPath.executionTimeout", "JSONPATH_EXECUTION_TIMEOUT", "jsonpath-execution-timeout", "time a jsonpath expression may run", &c.JsonPath.ExecutionTimeout},
+		{"jsonPath.maxOutputSize", "JSONPATH_MAX_OUTPUT_SIZE", "jsonpath-max-output-size", "maximum size of the jsonpath output in bytes", &c.JsonPath.MaxOutputSize},
+
+		{"category.maxConcurrency", "CATEGORY_MAX_CONCURRENCY", "category-max-concurrency", "maximum number of lists requested at the same time", &c.Category.MaxConcurrency},
+		{"category.resourceTimeout", "CATEGORY_RESOURCE_TIMEOUT", "category-resource-timeout", "time after which the request of a single list is given up", &c.Category.ResourceTimeout},
+
+		{"compression.minSize", "COMPRESSION_MIN_SIZE", "compression-min-size", "smaller responses aren't compressed", &c.Compression.MinSize},
+		{"compression.upstreamGzip", "COMPRESSION_UPSTREAM_GZIP", "compression-upstream-gzip", "request gzip compressed responses from the api servers", &c.Compression.UpstreamGzip},
+
+		{"cors.allowedOrigins", "CORS_ALLOWED_ORIGINS", "cors-allowed-origins", "comma separated origins or patterns allowed to use the backend from the browser", &c.CORS.AllowedOrigins},
+		{"cors.allowedMethods", "CORS_ALLOWED_METHODS", "cors-allowed-methods", "comma separated methods allowed in preflight requests", &c.CORS.AllowedMethods},
+		{"cors.allowedHeaders", "CORS_ALLOWED_HEADERS", "cors-allowed-headers", "comma separated request headers allowed in preflight requests", &c.CORS.AllowedHeaders},
+		{"cors.exposedHeaders", "CORS_EXPOSED_HEADERS", "cors-exposed-headers", "comma separated response headers readable by scripts", &c.CORS.ExposedHeaders},
+		{"cors.maxAge", "CORS_MAX_AGE", "cors-max-age", "time browsers cache preflight responses", &c.CORS.MaxAge},
+		{"cors.allowCredentials", "CORS_ALLOW_CREDENTIALS", "cors-allow-credentials", "allow requests with cookies", &c.CORS.AllowCredentials},
+
+		{"informers.resources", "INFORMER_RESOURCES", "informer-resources", "comma separated patterns of the resources served from informers", &c.Informers.Resources},
+		{"informers.idleTimeout", "INFORMER_IDLE_TIMEOUT", "informer-idle-timeout", "time after which an unused informer is stopped", &c.Informers.IdleTimeout},
+		{"informers.syncTimeout", "INFORMER_SYNC_TIMEOUT", "informer-sync-timeout", "time to wait for a new informer to sync", &c.Informers.SyncTimeout},
+		{"informers.accessReviewTTL", "INFORMER_ACCESS_REVIEW_TTL", "informer-access-review-ttl", "time access reviews are cached", &c.Informers.AccessReviewTTL},
+	}
+}
+
+// Options are the flags which control the program instead of configuring the server.
+type Options struct {
+	// File is the path of the config file, it is optional
+	File string
+	// PrintConfig prints the resulting config instead of starting the server
+	PrintConfig bool
+}
+
+// Load reads the config file set by --config or CONFIG_FILE, applies the env variables and the flags and validates
+// the result. Unknown fields and values which can't be parsed are errors instead of being ignored.
+func Load(args []string, output io.Writer) (Config, Options, error) {
+	var options Options
+	flags := flag.NewFlagSet("ui-backend", flag.ContinueOnError)
+	flags.SetOutput(output)
+	flags.StringVar(&options.File, "config", os.Getenv("CONFIG_FILE"), "path of the config file")
+	flags.BoolVar(&options.PrintConfig, "print-config", false, "print the config and exit")
+
+	// the flags are applied after the config file, which is only known after parsing them
+	config := Default()
+	flagValues := map[string]string{}
+	for _, s := range settings(&config) {
+		_, isBool := s.value.(*bool)
+		flags.Var(&deferredValue{isBool: isBool, defaultValue: formatValue(s.value), set: func(v string) { flagValues[s.flag] = v }},
+			s.flag, fmt.Sprintf("%s (env %s)", s.usage, s.env))
+	}
+	if err := flags.Parse(args); err != nil {
+		return Config{}, options, err
+	}
+	if flags.NArg() > 0 {
+		return Config{}, options, fmt.Errorf("unexpected arguments %v", flags.Args())
+	}
+
+	if options.File != "" {
+		if err := readFile(options.File, &config); err != nil {
+			return Config{}, options, err
+		}
+	}
+
+	for _, s := range settings(&config) {
+		// empty env variables are ignored, like unset ones
+		if v := os.Getenv(s.env); v != "" {
+			if err := setValue(s.value, v); err != nil {
+				return Config{}, options, fmt.Errorf("invalid value %q of env %s: %w", v, s.env, err)
+			}
+		}
+	}
+	for _, s := range settings(&config) {
+		if v, ok := flagValues[s.flag]; ok {
+			if err := setValue(s.value, v); err != nil {
+				return Config{}, options, fmt.Errorf("invalid value %q of flag -%s: %w", v, s.flag, err)
+			}
+		}
+	}
+
+	if err := config.Validate(); err != nil {
+		return Config{}, options, fmt.Errorf("invalid config:\n%w", err)
+	}
+	return config, options, nil
+}
+
+// readFile decodes the config file into the config, overriding only the fields which are set in the file.
+func readFile(path string, config *Config) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Print writes the config as YAML, in the format of the config file.
+func Print(w io.Writer, config Config) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(config); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+func setValue(value any, s string) error {
+	switch value := value.(type) {
+	case *string:
+		*value = s
+	case *int:
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		*value = i
+	case *int64:
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		*value = i
+	case *bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		*value = b
+	case *[]string:
+		var values []string
+		for _, v := range strings.Split(s, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		*value = values
+	case *Duration:
+		return value.Set(s)
+	default:
+		return fmt.Errorf("unsupported type %T", value)
+	}
+	return nil
+}
+
+func formatValue(value any) string {
+	switch value := value.(type) {
+	case *string:
+		return *value
+	case *int:
+		return strconv.Itoa(*value)
+	case *int64:
+		return strconv.FormatInt(*value, 10)
+	case *bool:
+		return strconv.FormatBool(*value)
+	case *[]string:
+		return strings.Join(*value, ",")
+	case *Duration:
+		return value.String()
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// deferredValue records the value of a flag, so it can be applied after the config file was read.
+type deferredValue struct {
+	isBool bool
+	// defaultValue is shown in the usage
+	defaultValue string
+	set          func(string)
+}
+
+func (v *deferredValue) String() string {
+	return v.defaultValue
+}
+
+func (v *deferredValue) Set(s string) error {
+	v.set(s)
+	return nil
+}
+
+func (v *deferredValue) IsBoolFlag() bool {
+	return v.isBool
+}
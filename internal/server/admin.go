@@ -0,0 +1,131 @@
+package server
+
+import (
+	"encoding/json"
+	"expvar"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/openmcp-project/ui-backend/pkg/k8s"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// NewAdminMux returns the endpoints to inspect and flush the caches, keyed by their name. They must only be reachable by
+// operators, as the entries reveal which clusters and identities were used recently.
+//
+//	GET  /debug/info                  the version, the crate kubeconfig status and the cache statistics
+//	GET  /cache/stats                 the statistics of every cache and its classes
+//	GET  /cache/entries               the cached entries, filtered by the query parameters cache, class, cluster, identity and namespace
+//	GET  /cache/kubeconfigs           the cached MCP kubeconfig secrets with their age
+//	POST /cache/flush                 removes the entries matching the same query parameters as /cache/entries
+//	GET  /debug/vars                  the cache statistics as expvar metrics
+//	GET  /metrics                     the prometheus metrics
+func NewAdminMux(caches map[string]k8s.CacheAdmin, version string) *http.ServeMux {
+	// expvar panics if a variable is published twice
+	if expvar.Get("cache") == nil {
+		expvar.Publish("cache", expvar.Func(func() any {
+			return cacheStats(caches)
+		}))
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /debug/info", debugInfoHandler(version, caches))
+	mux.HandleFunc("GET /cache/stats", func(w http.ResponseWriter, req *http.Request) {
+		writeAdminJSON(w, cacheStats(caches))
+	})
+	mux.HandleFunc("GET /cache/entries", func(w http.ResponseWriter, req *http.Request) {
+		selected, filter, err := selectCaches(caches, req)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		entries := map[string][]adminCacheEntry{}
+		for name, cache := range selected {
+			entries[name] = adminCacheEntries(cache.Entries(filter))
+		}
+		writeAdminJSON(w, entries)
+	})
+	mux.HandleFunc("GET /cache/kubeconfigs", func(w http.ResponseWriter, req *http.Request) {
+		crate, ok := caches["crate"]
+		if !ok {
+			writeError(w, NewNotFoundError("the crate isn't cached"))
+			return
+		}
+		writeAdminJSON(w, adminCacheEntries(crate.Entries(k8s.CacheFilter{Class: "secrets"})))
+	})
+	mux.HandleFunc("POST /cache/flush", func(w http.ResponseWriter, req *http.Request) {
+		selected, filter, err := selectCaches(caches, req)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		flushed := 0
+		for _, cache := range selected {
+			flushed += cache.Flush(filter)
+		}
+		writeAdminJSON(w, map[string]int{"flushed": flushed})
+	})
+	mux.Handle("GET /debug/vars", expvar.Handler())
+	mux.Handle("GET /metrics", promhttp.Handler())
+
+	return mux
+}
+
+// adminCacheEntry shows the durations of a cache entry readable instead of in nanoseconds.
+type adminCacheEntry struct {
+	k8s.CacheEntry
+	Age       string `json:"age"`
+	ExpiresIn string `json:"expiresIn"`
+}
+
+func adminCacheEntries(entries []k8s.CacheEntry) []adminCacheEntry {
+	result := make([]adminCacheEntry, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, adminCacheEntry{
+			CacheEntry: entry,
+			Age:        entry.Age.Round(time.Second).String(),
+			ExpiresIn:  entry.ExpiresIn.Round(time.Second).String(),
+		})
+	}
+	return result
+}
+
+func cacheStats(caches map[string]k8s.CacheAdmin) map[string]k8s.CacheStats {
+	stats := map[string]k8s.CacheStats{}
+	for name, cache := range caches {
+		stats[name] = cache.Stats()
+	}
+	return stats
+}
+
+// selectCaches returns the caches and the filter selected by the query parameters. Without the cache parameter, all
+// caches are selected.
+func selectCaches(caches map[string]k8s.CacheAdmin, req *http.Request) (map[string]k8s.CacheAdmin, k8s.CacheFilter, *HttpError) {
+	query := req.URL.Query()
+	filter := k8s.CacheFilter{
+		Class:     query.Get("class"),
+		Cluster:   query.Get("cluster"),
+		Identity:  query.Get("identity"),
+		Namespace: query.Get("namespace"),
+	}
+
+	name := query.Get("cache")
+	if name == "" {
+		return caches, filter, nil
+	}
+	cache, ok := caches[name]
+	if !ok {
+		return nil, filter, NewHttpError(http.StatusBadRequest, "unknown cache %q, expected one of %v", name, slices.Sorted(maps.Keys(caches)))
+	}
+	return map[string]k8s.CacheAdmin{name: cache}, filter, nil
+}
+
+func writeAdminJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("failed to write admin response", "err", err)
+	}
+}
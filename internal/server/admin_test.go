@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openmcp-project/ui-backend/pkg/k8s"
+)
+
+// newTestCaches returns a crate and an mcp cache, both with a cached secret and a list of configmaps of the clusters
+// https://a and https://b.
+func newTestCaches(t *testing.T) map[string]k8s.CacheAdmin {
+	t.Helper()
+
+	downstream := &fakeKube{responses: map[string]fakeResponse{
+		"/api/v1/namespaces/project-a/secrets/mcp":  {body: `{}`},
+		"/api/v1/namespaces/project-b/configmaps":   {body: `{"items":[]}`},
+		"/api/v1/namespaces/project-a/configmaps/x": {body: `{}`},
+	}}
+	policy := k8s.CachePolicy{MaxBytes: 1024 * 1024, MaxEntryBytes: 1024, SecretTTL: time.Minute, DefaultTTL: time.Minute}
+	caches := map[string]k8s.CacheAdmin{}
+	for _, name := range []string{"crate", "mcp"} {
+		kube := k8s.NewCachingKube(downstream, policy)
+		for _, server := range []string{"https://a", "https://b"} {
+			config, err := k8s.ParseKubeconfig("clusters:\n- cluster:\n    server: " + server + "\nusers:\n- user:\n    token: token\n")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for path := range downstream.responses {
+				res, err := kube.RequestApiServerRaw(context.Background(), k8s.Request{Method: "GET", Path: path}, config)
+				if err != nil {
+					t.Fatal(err)
+				}
+				_ = res.Body.Close()
+			}
+		}
+		caches[name] = kube.(k8s.CacheAdmin)
+	}
+	return caches
+}
+
+func TestAdminCacheEntries(t *testing.T) {
+	caches := newTestCaches(t)
+	mux := NewAdminMux(caches, "test")
+	// every path is cached for both clusters
+	allPaths := []string{
+		"/api/v1/namespaces/project-a/configmaps/x", "/api/v1/namespaces/project-a/configmaps/x",
+		"/api/v1/namespaces/project-a/secrets/mcp", "/api/v1/namespaces/project-a/secrets/mcp",
+		"/api/v1/namespaces/project-b/configmaps", "/api/v1/namespaces/project-b/configmaps",
+	}
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		// expectedEntries are the paths of the entries per cache, sorted
+		expectedEntries map[string][]string
+	}{
+		{
+			name:           "all entries",
+			expectedStatus: http.StatusOK,
+			expectedEntries: map[string][]string{
+				"crate": allPaths,
+				"mcp":   allPaths,
+			},
+		},
+		{
+			name:           "cache and class",
+			query:          "cache=crate&class=secrets",
+			expectedStatus: http.StatusOK,
+			expectedEntries: map[string][]string{
+				"crate": {"/api/v1/namespaces/project-a/secrets/mcp", "/api/v1/namespaces/project-a/secrets/mcp"},
+			},
+		},
+		{
+			name:           "cluster and namespace",
+			query:          "cluster=https://b&namespace=project-a",
+			expectedStatus: http.StatusOK,
+			expectedEntries: map[string][]string{
+				"crate": {"/api/v1/namespaces/project-a/configmaps/x", "/api/v1/namespaces/project-a/secrets/mcp"},
+				"mcp":   {"/api/v1/namespaces/project-a/configmaps/x", "/api/v1/namespaces/project-a/secrets/mcp"},
+			},
+		},
+		{
+			name:           "unknown cache",
+			query:          "cache=informers",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cache/entries?"+test.query, nil))
+			if rec.Code != test.expectedStatus {
+				t.Fatalf("expected status %d but got %d: %s", test.expectedStatus, rec.Code, rec.Body.String())
+			}
+			if test.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var entries map[string][]struct {
+				Path string `json:"path"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+				t.Fatalf("unexpected response: %v", err)
+			}
+			if len(entries) != len(test.expectedEntries) {
+				t.Errorf("expected the caches %v but got %v", test.expectedEntries, entries)
+			}
+			for name, expected := range test.expectedEntries {
+				var paths []string
+				for _, entry := range entries[name] {
+					paths = append(paths, entry.Path)
+				}
+				slices.Sort(paths)
+				if !slices.Equal(paths, expected) {
+					t.Errorf("expected the entries %q of cache %s but got %q", expected, name, paths)
+				}
+			}
+		})
+	}
+}
+
+func TestAdminCacheFlush(t *testing.T) {
+	caches := newTestCaches(t)
+	mux := NewAdminMux(caches, "test")
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cache/flush?cluster=https://a", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected flushing to require POST but got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/cache/flush?cache=mcp&cluster=https://a", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "{\"flushed\":3}\n" {
+		t.Fatalf("expected 3 flushed entries but got %d: %s", rec.Code, rec.Body.String())
+	}
+	for _, entry := range caches["mcp"].Entries(k8s.CacheFilter{}) {
+		if entry.Cluster == "https://a" {
+			t.Errorf("expected the entries of cluster a to be flushed but got %+v", entry)
+		}
+	}
+	if entries := caches["crate"].Entries(k8s.CacheFilter{}); len(entries) != 6 {
+		t.Errorf("expected the other cache to be unchanged but got %d entries", len(entries))
+	}
+}
+
+func TestAdminDebugInfo(t *testing.T) {
+	rec := httptest.NewRecorder()
+	NewAdminMux(newTestCaches(t), "v1.2.3").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/info", nil))
+
+	var info debugInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatalf("unexpected response: %v", err)
+	}
+	if info.Version != "v1.2.3" || info.Caches["crate"].Entries != 6 {
+		t.Errorf("expected the version and the cache statistics but got %+v", info)
+	}
+
+	rec = httptest.NewRecorder()
+	NewMiddleware(&fakeKube{}, &fakeKube{}, Config{}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/info", nil))
+	if strings.Contains(rec.Body.String(), "goVersion") {
+		t.Errorf("expected /debug/info not to be served publicly")
+	}
+}
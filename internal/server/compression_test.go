@@ -0,0 +1,28 @@
+package server
+
+import "testing"
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		encoding       string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", encodingGzip},
+		{"gzip, deflate, br", encodingBrotli},
+		{"br;q=0.5, gzip", encodingGzip},
+		{"br;q=0, gzip;q=0.1", encodingGzip},
+		{"*", encodingBrotli},
+		{"*;q=0.5, br;q=0", encodingGzip},
+		{"GZIP", encodingGzip},
+	}
+
+	for _, test := range tests {
+		t.Run(test.acceptEncoding, func(t *testing.T) {
+			if encoding := negotiateEncoding(test.acceptEncoding); encoding != test.encoding {
+				t.Errorf("expected %q but got %q", test.encoding, encoding)
+			}
+		})
+	}
+}
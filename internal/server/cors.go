@@ -0,0 +1,120 @@
+package server
+
+import (
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig restricts which web applications may use the backend from the browser.
+type CORSConfig struct {
+	// AllowedOrigins are origins like https://ui.example.com or patterns like https://*.example.com, * allows all
+	AllowedOrigins []string
+	// AllowedMethods are the methods allowed in preflight requests, * allows all
+	AllowedMethods []string
+	// AllowedHeaders are the request headers allowed in preflight requests, * allows all
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers scripts can read besides the safelisted ones
+	ExposedHeaders []string
+	// MaxAge is the time browsers cache the result of a preflight request, it is left to the browser if it is zero
+	MaxAge time.Duration
+	// AllowCredentials allows requests with cookies, * doesn't allow any origin then
+	AllowCredentials bool
+}
+
+// originAllowed returns whether the origin matches one of the allowed origins. In patterns, * matches any part of
+// the host, but no slash. With credentials, * alone doesn't match, so credentials are never sent to any origin.
+func (c CORSConfig) originAllowed(origin string) bool {
+	for _, pattern := range c.AllowedOrigins {
+		if pattern == "*" {
+			if !c.AllowCredentials {
+				return true
+			}
+			continue
+		}
+		if matched, _ := path.Match(pattern, origin); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// anyOrigin returns whether the responses are the same for all origins, so they can be cached independent of it.
+func (c CORSConfig) anyOrigin() bool {
+	return slices.Contains(c.AllowedOrigins, "*") && !c.AllowCredentials
+}
+
+// setHeaders sets the headers of a response to an actual request, i.e. not to a preflight request. Requests from
+// other origins are still handled, but the browser doesn't pass the response to the script.
+func (c CORSConfig) setHeaders(header http.Header, origin string) {
+	if !c.anyOrigin() {
+		header.Add("Vary", "Origin")
+	}
+	if origin == "" || !c.originAllowed(origin) {
+		return
+	}
+
+	if c.anyOrigin() {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+	if c.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if len(c.ExposedHeaders) > 0 {
+		header.Set("Access-Control-Expose-Headers", strings.Join(c.ExposedHeaders, ", "))
+	}
+}
+
+// handlePreflight answers an OPTIONS request. Preflight requests from origins which aren't allowed, or for methods or
+// headers which aren't allowed, are rejected.
+func (c CORSConfig) handlePreflight(w http.ResponseWriter, req *http.Request) {
+	origin := req.Header.Get("Origin")
+	method := req.Header.Get("Access-Control-Request-Method")
+	if origin == "" || method == "" {
+		// not a preflight request
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	// the answer depends on the requested method and headers, so shared caches must not reuse it for other requests
+	w.Header().Add("Vary", "Access-Control-Request-Method, Access-Control-Request-Headers")
+
+	if !c.originAllowed(origin) {
+		writeError(w, NewHttpError(http.StatusForbidden, "origin %s is not allowed", origin))
+		return
+	}
+	if !slices.Contains(c.AllowedMethods, "*") && !slices.Contains(c.AllowedMethods, method) {
+		writeError(w, NewHttpError(http.StatusForbidden, "method %s is not allowed", method))
+		return
+	}
+	var headers []string
+	for _, header := range strings.Split(req.Header.Get("Access-Control-Request-Headers"), ",") {
+		if header = strings.TrimSpace(header); header != "" {
+			headers = append(headers, header)
+		}
+	}
+	if !slices.Contains(c.AllowedHeaders, "*") {
+		for _, header := range headers {
+			if !slices.ContainsFunc(c.AllowedHeaders, func(allowed string) bool { return strings.EqualFold(allowed, header) }) {
+				writeError(w, NewHttpError(http.StatusForbidden, "header %s is not allowed", header))
+				return
+			}
+		}
+	}
+
+	c.setHeaders(w.Header(), origin)
+	// the requested method and headers are returned instead of *, as the wildcard doesn't apply to credentialed
+	// requests and the Authorization header
+	w.Header().Set("Access-Control-Allow-Methods", method)
+	if len(headers) > 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	}
+	if c.MaxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
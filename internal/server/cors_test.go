@@ -0,0 +1,152 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCORSPreflight(t *testing.T) {
+	config := CORSConfig{
+		AllowedOrigins:   []string{"https://ui.example.com", "https://*.preview.example.com"},
+		AllowedMethods:   []string{"GET", "POST"},
+		AllowedHeaders:   []string{"Authorization", "X-Project-Name"},
+		ExposedHeaders:   []string{"X-Response-From-Controlplane"},
+		MaxAge:           10 * time.Minute,
+		AllowCredentials: true,
+	}
+
+	tests := []struct {
+		name            string
+		origin          string
+		method          string
+		headers         string
+		expectedStatus  int
+		expectedOrigin  string
+		expectedHeaders string
+	}{
+		{
+			name:            "allowed origin",
+			origin:          "https://ui.example.com",
+			method:          "GET",
+			headers:         "authorization, x-project-name",
+			expectedStatus:  http.StatusNoContent,
+			expectedOrigin:  "https://ui.example.com",
+			expectedHeaders: "authorization, x-project-name",
+		},
+		{
+			name:           "origin matching pattern",
+			origin:         "https://pr-42.preview.example.com",
+			method:         "POST",
+			expectedStatus: http.StatusNoContent,
+			expectedOrigin: "https://pr-42.preview.example.com",
+		},
+		{
+			name:           "unknown origin",
+			origin:         "https://evil.example.org",
+			method:         "GET",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "pattern doesn't match other domains",
+			origin:         "https://evil.example.org/.preview.example.com",
+			method:         "GET",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "method not allowed",
+			origin:         "https://ui.example.com",
+			method:         "DELETE",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "header not allowed",
+			origin:         "https://ui.example.com",
+			method:         "GET",
+			headers:        "Authorization, X-Other",
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodOptions, "/api/v1/namespaces", nil)
+			req.Header.Set("Origin", test.origin)
+			req.Header.Set("Access-Control-Request-Method", test.method)
+			if test.headers != "" {
+				req.Header.Set("Access-Control-Request-Headers", test.headers)
+			}
+			rec := httptest.NewRecorder()
+			config.handlePreflight(rec, req)
+
+			if rec.Code != test.expectedStatus {
+				t.Errorf("expected status %d but got %d", test.expectedStatus, rec.Code)
+			}
+			if actual := rec.Header().Get("Access-Control-Allow-Origin"); actual != test.expectedOrigin {
+				t.Errorf("expected allowed origin %q but got %q", test.expectedOrigin, actual)
+			}
+			if actual := rec.Header().Get("Access-Control-Allow-Headers"); actual != test.expectedHeaders {
+				t.Errorf("expected allowed headers %q but got %q", test.expectedHeaders, actual)
+			}
+			if vary := rec.Header().Values("Vary"); !slices.Contains(vary, "Access-Control-Request-Method, Access-Control-Request-Headers") {
+				t.Errorf("expected Vary to contain the requested method and headers but got %q", vary)
+			}
+			if test.expectedStatus == http.StatusNoContent {
+				if rec.Header().Get("Access-Control-Allow-Credentials") != "true" || rec.Header().Get("Access-Control-Max-Age") != "600" {
+					t.Errorf("expected credentials and max age but got %v", rec.Header())
+				}
+			}
+		})
+	}
+}
+
+func TestCORSHeaders(t *testing.T) {
+	tests := []struct {
+		name           string
+		config         CORSConfig
+		origin         string
+		expectedOrigin string
+		expectedVary   string
+	}{
+		{
+			name:           "any origin",
+			config:         CORSConfig{AllowedOrigins: []string{"*"}},
+			origin:         "https://ui.example.com",
+			expectedOrigin: "*",
+		},
+		{
+			name:         "any origin with credentials isn't allowed",
+			config:       CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true},
+			origin:       "https://ui.example.com",
+			expectedVary: "Origin",
+		},
+		{
+			name:           "listed origin with credentials",
+			config:         CORSConfig{AllowedOrigins: []string{"*", "https://ui.example.com"}, AllowCredentials: true},
+			origin:         "https://ui.example.com",
+			expectedOrigin: "https://ui.example.com",
+			expectedVary:   "Origin",
+		},
+		{
+			name:         "unknown origin",
+			config:       CORSConfig{AllowedOrigins: []string{"https://ui.example.com"}},
+			origin:       "https://evil.example.org",
+			expectedVary: "Origin",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			header := http.Header{}
+			test.config.setHeaders(header, test.origin)
+			if actual := header.Get("Access-Control-Allow-Origin"); actual != test.expectedOrigin {
+				t.Errorf("expected allowed origin %q but got %q", test.expectedOrigin, actual)
+			}
+			if actual := header.Get("Vary"); actual != test.expectedVary {
+				t.Errorf("expected Vary %q but got %q", test.expectedVary, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/openmcp-project/ui-backend/pkg/k8s"
+)
+
+const (
+	etagHeader        = "ETag"
+	ifNoneMatchHeader = "If-None-Match"
+	// listETagPrefix marks ETags which are derived from the resourceVersion of a list
+	listETagPrefix = "rv-"
+)
+
+// bodyETag returns a strong ETag of the response body.
+func bodyETag(body []byte) string {
+	return `"` + shortHash(body) + `"`
+}
+
+// listETag returns the ETag of a list response. It consists of a hash of the resourceVersion of the list together with
+// the request it was returned for, and of the hash of the final body. The first part can be checked with a request
+// for a single item of the list, the second one still matches if the resourceVersion changed but the body didn't.
+func listETag(variant, resourceVersion string, body []byte) string {
+	return `"` + listETagPrefix + listVersionHash(variant, resourceVersion) + "-" + shortHash(body) + `"`
+}
+
+func listVersionHash(variant, resourceVersion string) string {
+	return shortHash([]byte(variant + "\x00" + resourceVersion))
+}
+
+func shortHash(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:16])
+}
+
+// parseETags returns the entity tags of If-None-Match headers. If-None-Match uses the weak comparison, so the weak
+// indicator is dropped.
+func parseETags(values []string) []string {
+	var tags []string
+	for _, value := range values {
+		for _, tag := range strings.Split(value, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+// etagWithEncoding returns the ETag of the representation compressed with the encoding.
+func etagWithEncoding(etag, encoding string) string {
+	return strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+}
+
+// etagBodyHash returns the part of the ETag which identifies the body, independent of the encoding.
+func etagBodyHash(etag string) string {
+	tag := strings.Trim(etag, `"`)
+	if rest, ok := strings.CutPrefix(tag, listETagPrefix); ok {
+		_, tag, _ = strings.Cut(rest, "-")
+	}
+	hash, _, _ := strings.Cut(tag, "-")
+	return hash
+}
+
+// etagMatches returns whether one of the If-None-Match tags refers to the same body as the ETag.
+func etagMatches(ifNoneMatch []string, etag string) bool {
+	hash := etagBodyHash(etag)
+	for _, tag := range parseETags(ifNoneMatch) {
+		if tag == "*" || etagBodyHash(tag) == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// hasListETag returns whether one of the If-None-Match tags is derived from the resourceVersion of a list.
+func hasListETag(ifNoneMatch []string) bool {
+	for _, tag := range parseETags(ifNoneMatch) {
+		if strings.HasPrefix(tag, `"`+listETagPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchingListETag returns the If-None-Match tag which was derived from the resourceVersion for the same request.
+func matchingListETag(ifNoneMatch []string, variant, resourceVersion string) (string, bool) {
+	prefix := `"` + listETagPrefix + listVersionHash(variant, resourceVersion) + "-"
+	for _, tag := range parseETags(ifNoneMatch) {
+		if strings.HasPrefix(tag, prefix) {
+			return tag, true
+		}
+	}
+	return "", false
+}
+
+// listETagVariant returns everything the body of a list response depends on besides the resourceVersion, and whether
+// the request can get a list ETag at all. Requests for a specific resourceVersion or page are excluded, because the
+// resourceVersion of their response isn't the current one.
+func listETagVariant(req *http.Request, data ExtractedRequestData, config k8s.KubeConfig) (string, bool) {
+	if req.Method != http.MethodGet || isWatchRequest(data) || isFollowLogRequest(data) {
+		return "", false
+	}
+	for _, parameter := range []string{"resourceVersion", "resourceVersionMatch", "continue"} {
+		if data.Query.Has(parameter) {
+			return "", false
+		}
+	}
+
+	return strings.Join([]string{
+		config.Clusters[0].Cluster.Server,
+		data.Path,
+		data.Query.Encode(),
+		req.Header.Get("Accept"),
+		data.JQ,
+		data.JsonPath,
+	}, "\x00"), true
+}
+
+// listResourceVersion returns the resourceVersion of a JSON encoded list response. Only the beginning of the body is
+// decoded, as kind and metadata precede the items.
+func listResourceVersion(res *http.Response, body io.Reader) (string, bool) {
+	if res.StatusCode != http.StatusOK || !strings.HasPrefix(res.Header.Get("Content-Type"), "application/json") {
+		return "", false
+	}
+
+	decoder := json.NewDecoder(body)
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return "", false
+	}
+	var kind, resourceVersion string
+	for decoder.More() && (kind == "" || resourceVersion == "") {
+		key, err := decoder.Token()
+		if err != nil {
+			return "", false
+		}
+		switch key {
+		case "kind":
+			err = decoder.Decode(&kind)
+		case "metadata":
+			var metadata struct {
+				ResourceVersion string `json:"resourceVersion"`
+			}
+			err = decoder.Decode(&metadata)
+			resourceVersion = metadata.ResourceVersion
+		default:
+			var skipped json.RawMessage
+			err = decoder.Decode(&skipped)
+		}
+		if err != nil {
+			return "", false
+		}
+	}
+
+	if !strings.HasSuffix(kind, "List") || resourceVersion == "" {
+		return "", false
+	}
+	return resourceVersion, true
+}
+
+// checkListVersion requests a single item of the list to get its current resourceVersion. If one of the If-None-Match tags
+// was derived from the same resourceVersion, it is returned, as the list didn't change.
+func (s *shared) checkListVersion(ctx context.Context, apiReq k8s.Request, config k8s.KubeConfig, variant string, ifNoneMatch []string) (string, bool) {
+	query := url.Values{}
+	for k, v := range apiReq.Query {
+		query[k] = v
+	}
+	query.Set("limit", "1")
+	headers := http.Header{}
+	for k, v := range apiReq.Headers {
+		headers[k] = v
+	}
+	headers.Set("Accept", "application/json")
+	headers.Del("Accept-Encoding")
+	headers.Del(ifNoneMatchHeader)
+
+	k8sResp, err := s.downstreamKube.RequestApiServerRaw(ctx, k8s.Request{
+		Method:  http.MethodGet,
+		Path:    apiReq.Path,
+		Query:   query,
+		Headers: headers,
+	}, config)
+	if err != nil {
+		slog.Debug("failed to check resourceVersion of list", "err", err)
+		return "", false
+	}
+	defer k8sResp.Body.Close()
+
+	resourceVersion, ok := listResourceVersion(k8sResp, k8sResp.Body)
+	if !ok {
+		return "", false
+	}
+	return matchingListETag(ifNoneMatch, variant, resourceVersion)
+}
@@ -0,0 +1,51 @@
+package server
+
+import "testing"
+
+func TestETagMatches(t *testing.T) {
+	body := []byte(`{"items":[]}`)
+	etag := bodyETag(body)
+	list := listETag("variant", "42", body)
+
+	tests := []struct {
+		name        string
+		ifNoneMatch []string
+		etag        string
+		matches     bool
+	}{
+		{"same etag", []string{etag}, etag, true},
+		{"weak etag", []string{"W/" + etag}, etag, true},
+		{"one of many", []string{`"other", ` + etag}, etag, true},
+		{"wildcard", []string{"*"}, etag, true},
+		{"other etag", []string{`"other"`}, etag, false},
+		{"no etag", nil, etag, false},
+		{"same list", []string{list}, list, true},
+		{"same body of other list version", []string{listETag("variant", "43", body)}, list, true},
+		{"list etag of the same body", []string{list}, etag, true},
+		{"other body of same list version", []string{listETag("variant", "42", []byte("{}"))}, list, false},
+		{"other encoding", []string{etagWithEncoding(etag, encodingGzip)}, etagWithEncoding(etag, encodingBrotli), true},
+		{"encoded list", []string{etagWithEncoding(list, encodingGzip)}, list, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if matches := etagMatches(test.ifNoneMatch, test.etag); matches != test.matches {
+				t.Errorf("expected matches to be %v but got %v", test.matches, matches)
+			}
+		})
+	}
+}
+
+func TestMatchingListETag(t *testing.T) {
+	list := listETag("variant", "42", []byte(`{"items":[]}`))
+
+	if tag, ok := matchingListETag([]string{`"other", ` + list}, "variant", "42"); !ok || tag != list {
+		t.Errorf("expected %s to match but got %s", list, tag)
+	}
+	if _, ok := matchingListETag([]string{list}, "variant", "43"); ok {
+		t.Errorf("expected other resourceVersion not to match")
+	}
+	if _, ok := matchingListETag([]string{list}, "other variant", "42"); ok {
+		t.Errorf("expected other request not to match")
+	}
+}
@@ -1,12 +1,20 @@
 package server
 
 import (
+	"cmp"
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"time"
 
 	"github.com/openmcp-project/ui-backend/pkg/k8s"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
@@ -18,12 +26,45 @@ type JQConfig struct {
 	MaxResults          int
 }
 
+type JsonPathConfig struct {
+	MaxExpressionLength int
+	ExecutionTimeout    time.Duration
+	MaxOutputSize       int
+}
+
+type CategoryConfig struct {
+	// MaxConcurrency is the maximum number of resource lists requested at the same time for a category
+	MaxConcurrency int
+	// ResourceTimeout is the time after which the request for a single resource list is given up
+	ResourceTimeout time.Duration
+}
+
+type Config struct {
+	JQ          JQConfig
+	JsonPath    JsonPathConfig
+	Category    CategoryConfig
+	Compression CompressionConfig
+	CORS        CORSConfig
+	// Informers serves frequently requested resources from informers, it is optional
+	Informers *k8s.InformerCache
+	// Health is served at /healthz and /readyz, it is optional
+	Health *Health
+}
+
 type shared struct {
-	crateKube      k8s.Kube
-	downstreamKube k8s.Kube
-	jqConfig       JQConfig
+	crateKube         k8s.Kube
+	downstreamKube    k8s.Kube
+	jqConfig          JQConfig
+	jsonPathConfig    JsonPathConfig
+	categoryConfig    CategoryConfig
+	compressionConfig CompressionConfig
+	corsConfig        CORSConfig
+	informers         *k8s.InformerCache
+	health            *Health
 }
 
+var tracer = otel.Tracer("github.com/openmcp-project/ui-backend/internal/server")
+
 type handler func(shared *shared, req *http.Request, res *response) (*response, *HttpError)
 
 type response struct {
@@ -31,6 +72,8 @@ type response struct {
 	contentType string
 	statusCode  int
 	headers     map[string]string
+	// stream is set for responses which are written incrementally instead of from body, e.g. watch requests
+	stream func(w http.ResponseWriter, req *http.Request) error
 }
 
 func (r *response) AddHeader(key, value string) {
@@ -40,45 +83,106 @@ func (r *response) AddHeader(key, value string) {
 	r.headers[key] = value
 }
 
-func defaultHandler(shared *shared, handlerFunc handler) func(w http.ResponseWriter, r *http.Request) {
+func defaultHandler(shared *shared, handlerName string, handlerFunc handler) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, req *http.Request) {
 		if (*req).Method == "OPTIONS" {
-			handleOptions(w)
+			shared.corsConfig.handlePreflight(w, req)
 			return
 		}
+		shared.corsConfig.setHeaders(w.Header(), req.Header.Get("Origin"))
+
+		// handlers remove the headers which aren't forwarded to the api server from the request
+		acceptEncoding := req.Header.Get("Accept-Encoding")
+		metrics := newRequestMetrics(handlerName, req)
+
+		ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+		ctx, span := tracer.Start(ctx, "handler."+handlerName, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("url.path", req.URL.Path),
+		))
+		defer span.End()
+		if shared.health != nil && isStreamingRequest(handlerName, req) {
+			var cancel context.CancelFunc
+			ctx, cancel = shared.health.streamContext(ctx)
+			defer cancel()
+		}
+		req = req.WithContext(ctx)
 
 		res := &response{}
 		res, err := handlerFunc(shared, req, res)
-		w.Header().Set("Access-Control-Allow-Origin", "*")
 
 		if err != nil {
-			slog.Error("request processing failed", "err", err)
+			span.SetStatus(codes.Error, err.Message)
+			span.SetAttributes(attribute.Int("http.response.status_code", err.Code))
+			writeError(w, err)
+			metrics.observe(err.Code, false)
+			return
+		}
+		defer func() {
+			span.SetAttributes(attribute.Int("http.response.status_code", cmp.Or(res.statusCode, http.StatusOK)))
+			metrics.observe(res.statusCode, res.stream != nil)
+		}()
 
-			status := err.ToAPIStatus()
-			var encoder = unstructured.NewJSONFallbackEncoder(unstructured.UnstructuredJSONScheme)
-			output, errEnc := runtime.Encode(encoder, status)
-			if errEnc != nil {
-				output = []byte(fmt.Sprintf("%s: %s", status.Reason, status.Message))
+		encoding := shared.responseEncoding(acceptEncoding, res)
+		if isConditionalResponse(req, res) {
+			etag := res.headers[etagHeader]
+			if etag == "" {
+				etag = bodyETag(res.body)
 			}
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(int(status.Code))
-			if _, errWrite := w.Write(output); errWrite != nil {
-				utilruntime.HandleError(fmt.Errorf("proxy was unable to write a fallback JSON response: %v", errWrite))
+			// the encoded representations need their own ETags
+			if encoding != "" {
+				etag = etagWithEncoding(etag, encoding)
+			}
+			res.AddHeader(etagHeader, etag)
+			if etagMatches(req.Header.Values(ifNoneMatchHeader), etag) {
+				res.statusCode = http.StatusNotModified
+				res.body = nil
+				delete(res.headers, "Content-Length")
 			}
-			return
 		}
+		if encoding != "" && res.statusCode != http.StatusNotModified {
+			compressed, errCompress := compress(res.body, encoding)
+			if errCompress != nil {
+				res.statusCode = http.StatusInternalServerError
+				writeError(w, NewInternalServerError("failed to compress response: %v", errCompress))
+				return
+			}
+			res.body = compressed
+			res.AddHeader("Content-Encoding", encoding)
+			delete(res.headers, "Content-Length")
+		}
+
+		_, writeSpan := tracer.Start(ctx, "writeResponse")
+		defer writeSpan.End()
 
 		if res.contentType != "" {
 			w.Header().Set("Content-Type", res.contentType)
 		}
 		if res.headers != nil {
 			for k, v := range res.headers {
-				w.Header().Set(k, v)
+				// Vary is also set for CORS
+				if k == "Vary" {
+					w.Header().Add(k, v)
+				} else {
+					w.Header().Set(k, v)
+				}
 			}
 		}
 		if res.statusCode > 0 {
 			w.WriteHeader(res.statusCode)
 		}
+		if res.stream != nil {
+			// streams last until the client or the api server ends them, so the server timeouts don't apply
+			controller := http.NewResponseController(w)
+			if errDeadline := errors.Join(controller.SetReadDeadline(time.Time{}), controller.SetWriteDeadline(time.Time{})); errDeadline != nil {
+				slog.Warn("failed to clear deadlines of streamed response", "err", errDeadline)
+			}
+			// streams end with an error when the client disconnects or the server shuts down
+			if errStream := res.stream(w, req); errStream != nil && req.Context().Err() == nil {
+				slog.Error("streaming response failed", "err", errStream)
+			}
+			return
+		}
 		if _, errWrite := w.Write(res.body); errWrite != nil {
 			slog.Error("can't write response", "err", err)
 			utilruntime.HandleError(fmt.Errorf("was unable to write a response: %v", errWrite))
@@ -86,9 +190,41 @@ func defaultHandler(shared *shared, handlerFunc handler) func(w http.ResponseWri
 	}
 }
 
-func handleOptions(w http.ResponseWriter) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "*")
-	w.Header().Set("Access-Control-Allow-Headers", "*")
-	w.WriteHeader(http.StatusOK)
+// responseEncoding returns the encoding the response body is compressed with, or an empty string if it isn't compressed.
+// Streamed responses and bodies which are already encoded (e.g. gzip passed through from the api server) aren't compressed.
+func (s *shared) responseEncoding(acceptEncoding string, res *response) string {
+	if res.stream != nil || res.statusCode == http.StatusNotModified {
+		return ""
+	}
+	if _, encoded := res.headers["Content-Encoding"]; encoded {
+		res.AddHeader("Vary", "Accept-Encoding")
+		return ""
+	}
+	if s.compressionConfig.MinSize < 0 || len(res.body) < s.compressionConfig.MinSize {
+		return ""
+	}
+	res.AddHeader("Vary", "Accept-Encoding")
+	return negotiateEncoding(acceptEncoding)
+}
+
+// isConditionalResponse returns whether the response gets an ETag, which is the case for complete responses to GET requests.
+func isConditionalResponse(req *http.Request, res *response) bool {
+	return req.Method == http.MethodGet && res.stream == nil && (res.statusCode == 0 || res.statusCode == http.StatusOK)
+}
+
+// writeError writes the error as kubernetes Status object, so clients can handle it like an error of the api server.
+func writeError(w http.ResponseWriter, err *HttpError) {
+	slog.Error("request processing failed", "err", err)
+
+	status := err.ToAPIStatus()
+	var encoder = unstructured.NewJSONFallbackEncoder(unstructured.UnstructuredJSONScheme)
+	output, errEnc := runtime.Encode(encoder, status)
+	if errEnc != nil {
+		output = []byte(fmt.Sprintf("%s: %s", status.Reason, status.Message))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(int(status.Code))
+	if _, errWrite := w.Write(output); errWrite != nil {
+		utilruntime.HandleError(fmt.Errorf("proxy was unable to write a fallback JSON response: %v", errWrite))
+	}
 }
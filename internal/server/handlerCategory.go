@@ -3,13 +3,15 @@ package server
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"strconv"
 
-	"github.com/openmcp-project/ui-backend/internal/utils"
 	"github.com/openmcp-project/ui-backend/pkg/k8s"
-	"github.com/openmcp-project/ui-backend/pkg/openmcp"
 )
 
 func managedHandler(s *shared, req *http.Request, res *response) (*response, *HttpError) {
@@ -30,7 +32,8 @@ func categoryHandler(s *shared, req *http.Request, res *response) (*response, *H
 	return _categoryHandler(s, req, res)
 }
 
-// This handler creates an endpoint for the client to get all crossplane managed resources.
+// This handler creates an endpoint for the client to get all resources of a category, e.g. all crossplane managed resources.
+// The response is a CategoryResult, with ?flatten=true the items of all lists are merged into CategoryResult.Items.
 func _categoryHandler(s *shared, req *http.Request, res *response) (*response, *HttpError) {
 	data, err := extractRequestData(req)
 	if err != nil {
@@ -39,83 +42,68 @@ func _categoryHandler(s *shared, req *http.Request, res *response) (*response, *
 
 	DeleteMultiple(data.Headers, prohibitedRequestHeaders)
 
-	crateKubeconfig, ok := utils.GetCrateKubeconfig()
-	if !ok {
-		slog.Error("failed to get crate kubeconfig")
-		return nil, NewInternalServerError("failed to get crate kubeconfig")
-	}
-
-	var config k8s.KubeConfig
-	if data.ProjectName != "" && data.WorkspaceName != "" && data.McpName != "" {
-		config, err = openmcp.GetControlPlaneKubeconfig(s.crateKube, data.ProjectName, data.WorkspaceName, data.McpName, data.CrateAuthorizationToken, crateKubeconfig)
-		if err != nil {
-			slog.Error("failed to get control plane api config", "err", err)
-			return nil, NewInternalServerError("failed to get control plane api config")
-		}
-		if data.McpAuthorizationToken == "" {
-			slog.Error("MCP authorization token not provided")
-			return nil, NewBadRequestError("MCP authorization token not provided")
-		}
-		config.SetUserToken(data.McpAuthorizationToken)
-	} else {
-		slog.Error("either use %s: true or provide %s, %s and %s headers", useCrateClusterHeader, projectNameHeader, workspaceNameHeader, mcpName)
-		return nil, NewBadRequestError(
-			"either use %s: true or provide %s, %s and %s headers",
-			useCrateClusterHeader,
-			projectNameHeader,
-			workspaceNameHeader,
-			mcpName,
-		)
+	config, serviceConfig, httpErr := s.resolveKubeconfigs(req.Context(), data, false)
+	if httpErr != nil {
+		return nil, httpErr
 	}
 
 	if data.Category == "" {
 		return nil, NewBadRequestError("category not provided")
 	}
+	if len(data.JQ) > s.jqConfig.MaxExpressionLength {
+		return nil, NewBadRequestError("jq expression exceeds maximum allowed length")
+	}
+	if len(data.JsonPath) > s.jsonPathConfig.MaxExpressionLength {
+		return nil, NewBadRequestError("jsonpath expression exceeds maximum allowed length")
+	}
 
 	res.AddHeader("X-Response-From-Controlplane", "true")
 
-	categories, err := s.downstreamKube.RequestApiGroupsByCategory(config, data.Category)
+	categories, err := s.downstreamKube.RequestApiGroupsByCategory(req.Context(), config, data.Category)
 	if err != nil {
 		slog.Error("failed to get managed resources", "err", err)
 		return nil, NewInternalServerError("failed to get managed resources")
 	}
 
-	resultData := make([][]byte, 0)
+	var resources []categoryResource
 	for _, category := range categories {
 		for _, version := range category.Versions {
 			for _, resource := range version.Resources {
-				apiReq := k8s.Request{
-					Method:  "GET",
-					Path:    "/apis/" + category.Name + "/" + version.Version + "/" + resource.Resource,
-					Headers: data.Headers,
+				kind := ""
+				if resource.ResponseKind != nil {
+					kind = resource.ResponseKind.Kind
 				}
-
-				k8sResp, err := s.downstreamKube.RequestApiServerRaw(apiReq, config)
-				if err != nil {
-					slog.Error("failed to get managed resources", "err", err)
-					return nil, NewInternalServerError("failed to get managed resources")
-				}
-
-				data, err := io.ReadAll(k8sResp.Body)
-				if err != nil {
-					slog.Error("failed to read data from response", "err", err)
-					return nil, NewInternalServerError("failed to read data from response")
-				}
-
-				resultData = append(resultData, data)
+				resources = append(resources, categoryResource{
+					group:    category.Name,
+					version:  version.Version,
+					resource: resource.Resource,
+					kind:     kind,
+				})
 			}
 		}
 	}
 
-	var result []byte = append([]byte("["), bytes.Join(resultData, []byte(","))[:]...)
-	result = append(result, []byte("]")[:]...)
+	flatten, _ := strconv.ParseBool(data.Query.Get("flatten"))
+	kube := k8s.WithInformers(s.downstreamKube, s.informers, serviceConfig)
+	results := s.fetchCategoryResources(req.Context(), kube, config, data.Headers, resources)
 
-	if data.JQ != "" {
-		if len(data.JQ) > s.jqConfig.MaxExpressionLength {
-			return nil, NewBadRequestError("jq expression exceeds maximum allowed length")
-		}
+	// the response is buffered until all lists are available, so X-Partial-Response can be sent as header and the body
+	// gets an ETag and can be compressed
+	var buf bytes.Buffer
+	categoryErrors, err := writeCategoryResults(&buf, resources, results, flatten)
+	if err != nil {
+		return nil, NewInternalServerError("failed to build response: %v", err)
+	}
+	if len(categoryErrors) > 0 {
+		res.AddHeader(partialResponseHeader, "true")
+	}
+	result := buf.Bytes()
+	res.contentType = "application/json"
 
-		ctx, cancel := context.WithTimeout(req.Context(), s.jqConfig.ExecutionTimeout)
+	if data.JQ != "" {
+		ctx, span := tracer.Start(req.Context(), "jq")
+		defer span.End()
+		ctx, cancel := context.WithTimeout(ctx, s.jqConfig.ExecutionTimeout)
 		defer cancel()
 
 		resultString, err := ParseJQ(ctx, result, data.JQ, s.jqConfig.MaxResults)
@@ -125,9 +113,284 @@ func _categoryHandler(s *shared, req *http.Request, res *response) (*response, *
 		}
 
 		result = []byte(resultString)
+	} else if data.JsonPath != "" {
+		ctx, span := tracer.Start(req.Context(), "jsonpath")
+		defer span.End()
+		ctx, cancel := context.WithTimeout(ctx, s.jsonPathConfig.ExecutionTimeout)
+		defer cancel()
+
+		output, err := ParseJsonPath(ctx, result, data.JsonPath, s.jsonPathConfig.MaxOutputSize)
+		if err != nil {
+			slog.Error("jsonpath execution failed", "err", err)
+			return nil, NewInternalServerError("failed to process jsonpath expression")
+		}
+
+		result = []byte(output)
+		res.contentType = "text/plain; charset=utf-8"
 	}
 
 	res.body = result
 
 	return res, nil
 }
+
+// partialResponseHeader is set to true if some of the resource lists couldn't be retrieved.
+const partialResponseHeader = "X-Partial-Response"
+
+var errCategoryResourceTimeout = errors.New("request timed out")
+
+type categoryResource struct {
+	group    string
+	version  string
+	resource string
+	kind     string
+}
+
+type categoryResourceResult struct {
+	body []byte
+	err  error
+}
+
+// CategoryResult is the response of the category endpoints.
+type CategoryResult struct {
+	// Resources contains one entry per resource of the category, unless the result is flattened
+	Resources []CategoryResourceResult `json:"resources,omitempty"`
+	// Items contains the items of all lists with apiVersion and kind set, if the result is flattened
+	Items []json.RawMessage `json:"items,omitempty"`
+	// Errors contains the resources which couldn't be listed
+	Errors []CategoryError `json:"errors"`
+}
+
+// CategoryResourceResult is either the list of a resource or the reason why it couldn't be listed.
+type CategoryResourceResult struct {
+	Group    string                `json:"group"`
+	Version  string                `json:"version"`
+	Resource string                `json:"resource"`
+	Kind     string                `json:"kind"`
+	List     json.RawMessage       `json:"list,omitempty"`
+	Error    *CategoryResultStatus `json:"error,omitempty"`
+}
+
+type CategoryResultStatus struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// CategoryError describes why the list of a single resource is missing in the response.
+type CategoryError struct {
+	Group    string `json:"group"`
+	Version  string `json:"version"`
+	Resource string `json:"resource"`
+	CategoryResultStatus
+}
+
+func newCategoryError(resource categoryResource, err error) CategoryError {
+	categoryErr := CategoryError{
+		Group:    resource.group,
+		Version:  resource.version,
+		Resource: resource.resource,
+		CategoryResultStatus: CategoryResultStatus{
+			Status:  http.StatusBadGateway,
+			Message: err.Error(),
+		},
+	}
+
+	var statusErr *k8s.StatusError
+	if errors.As(err, &statusErr) {
+		categoryErr.Status = statusErr.Code
+		categoryErr.Message = statusErr.Message
+	} else if errors.Is(err, errCategoryResourceTimeout) {
+		categoryErr.Status = http.StatusGatewayTimeout
+	}
+
+	return categoryErr
+}
+
+// apiVersion returns the apiVersion of the resource as used in the objects, e.g. "v1" for the core group.
+func (r categoryResource) apiVersion() string {
+	if r.group == "" {
+		return r.version
+	}
+	return r.group + "/" + r.version
+}
+
+// flattenList returns the items of the list with apiVersion and kind set, which are omitted in the items of some lists.
+func flattenList(resource categoryResource, list []byte) ([]json.RawMessage, error) {
+	var parsed struct {
+		Items []map[string]json.RawMessage `json:"items"`
+	}
+	if err := json.Unmarshal(list, &parsed); err != nil {
+		return nil, fmt.Errorf("failed to decode list: %w", err)
+	}
+
+	apiVersion, err := json.Marshal(resource.apiVersion())
+	if err != nil {
+		return nil, err
+	}
+	kind, err := json.Marshal(resource.kind)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]json.RawMessage, 0, len(parsed.Items))
+	for _, item := range parsed.Items {
+		if _, ok := item["apiVersion"]; !ok {
+			item["apiVersion"] = apiVersion
+		}
+		if _, ok := item["kind"]; !ok && resource.kind != "" {
+			item["kind"] = kind
+		}
+		b, err := json.Marshal(item)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, b)
+	}
+	return items, nil
+}
+
+// fetchCategoryResources requests the lists of all resources concurrently, using at most CategoryConfig.MaxConcurrency
+// requests at a time. Every resource gets its own result channel, so the results can be consumed in a deterministic order.
+func (s *shared) fetchCategoryResources(ctx context.Context, kube k8s.Kube, config k8s.KubeConfig, headers map[string][]string, resources []categoryResource) []chan categoryResourceResult {
+	results := make([]chan categoryResourceResult, len(resources))
+	for i := range results {
+		results[i] = make(chan categoryResourceResult, 1)
+	}
+
+	jobs := make(chan int)
+	go func() {
+		defer close(jobs)
+		for i := range resources {
+			jobs <- i
+		}
+	}()
+
+	workers := min(max(s.categoryConfig.MaxConcurrency, 1), len(resources))
+	for range workers {
+		go func() {
+			for i := range jobs {
+				results[i] <- s.fetchCategoryResource(ctx, kube, config, headers, resources[i])
+			}
+		}()
+	}
+
+	return results
+}
+
+// fetchCategoryResource requests the list of a single resource. If the api server doesn't answer within
+// CategoryConfig.ResourceTimeout, the request is given up.
+func (s *shared) fetchCategoryResource(ctx context.Context, kube k8s.Kube, config k8s.KubeConfig, headers map[string][]string, resource categoryResource) categoryResourceResult {
+	apiReq := k8s.Request{
+		Method:  "GET",
+		Path:    k8s.ResourcePath(resource.group, resource.version, resource.resource),
+		Headers: headers,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.categoryConfig.ResourceTimeout)
+	defer cancel()
+	failed := func(err error) categoryResourceResult {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("request for %s failed: %w", apiReq.Path, errCategoryResourceTimeout)
+		}
+		return categoryResourceResult{err: err}
+	}
+
+	k8sResp, err := kube.RequestApiServerRaw(ctx, apiReq, config)
+	if err != nil {
+		return failed(err)
+	}
+	defer func(Body io.ReadCloser) {
+		errC := Body.Close()
+		if errC != nil {
+			slog.Error("failed to close api server response body", "err", errC)
+		}
+	}(k8sResp.Body)
+
+	body, err := io.ReadAll(k8sResp.Body)
+	if err != nil {
+		return failed(fmt.Errorf("failed to read data from response: %w", err))
+	}
+	if k8sResp.StatusCode >= 400 {
+		return categoryResourceResult{err: k8s.NewStatusError(k8sResp.StatusCode, body)}
+	}
+	return categoryResourceResult{body: body}
+}
+
+// writeCategoryResults writes the CategoryResult. The entries are written in the order of the resources, each one as soon
+// as it is available. Resources which couldn't be listed are additionally collected in the errors.
+func writeCategoryResults(w io.Writer, resources []categoryResource, results []chan categoryResourceResult, flatten bool) ([]CategoryError, error) {
+	categoryErrors := []CategoryError{}
+	start := `{"resources":[`
+	if flatten {
+		start = `{"items":[`
+	}
+	if _, err := io.WriteString(w, start); err != nil {
+		return categoryErrors, err
+	}
+
+	written := 0
+	writeEntry := func(entry []byte) error {
+		if written > 0 {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		written++
+		_, err := w.Write(entry)
+		return err
+	}
+
+	for i, result := range results {
+		resource := resources[i]
+		r := <-result
+
+		var entries []json.RawMessage
+		if r.err == nil && flatten {
+			entries, r.err = flattenList(resource, r.body)
+		}
+
+		if r.err != nil {
+			slog.Error("failed to get resource list", "err", r.err)
+			categoryErr := newCategoryError(resource, r.err)
+			categoryErrors = append(categoryErrors, categoryErr)
+			if !flatten {
+				entry, err := json.Marshal(CategoryResourceResult{
+					Group:    resource.group,
+					Version:  resource.version,
+					Resource: resource.resource,
+					Kind:     resource.kind,
+					Error:    &categoryErr.CategoryResultStatus,
+				})
+				if err != nil {
+					return categoryErrors, err
+				}
+				entries = append(entries, entry)
+			}
+		} else if !flatten {
+			entry, err := json.Marshal(CategoryResourceResult{
+				Group:    resource.group,
+				Version:  resource.version,
+				Resource: resource.resource,
+				Kind:     resource.kind,
+				List:     r.body,
+			})
+			if err != nil {
+				return categoryErrors, err
+			}
+			entries = append(entries, entry)
+		}
+
+		for _, entry := range entries {
+			if err := writeEntry(entry); err != nil {
+				return categoryErrors, err
+			}
+		}
+	}
+
+	errorsJson, err := json.Marshal(categoryErrors)
+	if err != nil {
+		return categoryErrors, err
+	}
+	_, err = io.WriteString(w, `],"errors":`+string(errorsJson)+"}")
+	return categoryErrors, err
+}
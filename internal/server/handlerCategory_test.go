@@ -0,0 +1,334 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/openmcp-project/ui-backend/pkg/k8s"
+	"k8s.io/api/apidiscovery/v2beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var testCategoryConfig = CategoryConfig{MaxConcurrency: 10, ResourceTimeout: 5 * time.Second}
+
+// testCategoryGroups returns the discovery of the resources in the group example.com/v1, which are listed at
+// /apis/example.com/v1/<resource>.
+func testCategoryGroups(resources ...string) []v2beta1.APIGroupDiscovery {
+	version := v2beta1.APIVersionDiscovery{Version: "v1"}
+	for _, resource := range resources {
+		version.Resources = append(version.Resources, v2beta1.APIResourceDiscovery{
+			Resource:     resource,
+			ResponseKind: &metav1.GroupVersionKind{Group: "example.com", Version: "v1", Kind: resource},
+		})
+	}
+	group := v2beta1.APIGroupDiscovery{Versions: []v2beta1.APIVersionDiscovery{version}}
+	group.Name = "example.com"
+	return []v2beta1.APIGroupDiscovery{group}
+}
+
+// requestCategory sends a category request to the control plane of newCrateKube and decodes the CategoryResult.
+func requestCategory(t *testing.T, kube *fakeKube, config CategoryConfig, target string) (CategoryResult, *httptest.ResponseRecorder) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	NewMiddleware(newCrateKube(), kube, Config{Category: config}).ServeHTTP(rec, newMcpRequest(target))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 but got %d: %s", rec.Code, rec.Body.String())
+	}
+	var result CategoryResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("expected a CategoryResult but got: %v", err)
+	}
+	return result, rec
+}
+
+func TestCategoryHandlerOrdersResources(t *testing.T) {
+	loadTestKubeconfig(t)
+	// the resources complete in the reverse order
+	kube := &fakeKube{
+		groups: testCategoryGroups("a", "b", "c"),
+		responses: map[string]fakeResponse{
+			"/apis/example.com/v1/a": {body: `{"items":[]}`, delay: 60 * time.Millisecond},
+			"/apis/example.com/v1/b": {body: `{"items":[]}`, delay: 30 * time.Millisecond},
+			"/apis/example.com/v1/c": {body: `{"items":[]}`},
+		},
+	}
+
+	result, _ := requestCategory(t, kube, testCategoryConfig, "/c/test")
+	var order []string
+	for _, resource := range result.Resources {
+		order = append(order, resource.Resource)
+	}
+	if !slices.Equal(order, []string{"a", "b", "c"}) {
+		t.Errorf("expected the resources in the order of the discovery but got %q", order)
+	}
+}
+
+func TestCategoryHandlerLimitsConcurrency(t *testing.T) {
+	loadTestKubeconfig(t)
+	resources := []string{"a", "b", "c", "d", "e", "f"}
+	kube := &fakeKube{groups: testCategoryGroups(resources...), responses: map[string]fakeResponse{}}
+	for _, resource := range resources {
+		kube.responses["/apis/example.com/v1/"+resource] = fakeResponse{body: `{"items":[]}`, delay: 20 * time.Millisecond}
+	}
+
+	config := testCategoryConfig
+	config.MaxConcurrency = 2
+	result, _ := requestCategory(t, kube, config, "/c/test")
+	if len(result.Resources) != len(resources) || len(result.Errors) != 0 {
+		t.Errorf("expected all resources without errors but got %+v", result)
+	}
+	if kube.maxInFlight.Load() != 2 {
+		t.Errorf("expected at most 2 concurrent requests but got %d", kube.maxInFlight.Load())
+	}
+}
+
+func TestCategoryHandlerResourceTimeout(t *testing.T) {
+	loadTestKubeconfig(t)
+	kube := &fakeKube{
+		groups: testCategoryGroups("fast", "slow"),
+		responses: map[string]fakeResponse{
+			"/apis/example.com/v1/fast": {body: `{"items":[]}`},
+			// slow is never released
+			"/apis/example.com/v1/slow": {body: `{"items":[]}`, release: make(chan struct{})},
+		},
+	}
+
+	config := testCategoryConfig
+	config.ResourceTimeout = 50 * time.Millisecond
+	result, _ := requestCategory(t, kube, config, "/c/test")
+	if result.Resources[0].Error != nil || result.Resources[0].List == nil {
+		t.Errorf("expected the list of the fast resource but got %+v", result.Resources[0])
+	}
+	if len(result.Errors) != 1 || result.Errors[0].Resource != "slow" || result.Errors[0].Status != http.StatusGatewayTimeout {
+		t.Errorf("expected a timeout of the slow resource but got %+v", result.Errors)
+	}
+}
+
+func TestCategoryHandlerPartialResponse(t *testing.T) {
+	loadTestKubeconfig(t)
+	kube := &fakeKube{
+		groups: testCategoryGroups("allowed", "forbidden"),
+		responses: map[string]fakeResponse{
+			"/apis/example.com/v1/allowed": {body: `{"items":[{"metadata":{"name":"a"}}]}`},
+			"/apis/example.com/v1/forbidden": {
+				status: http.StatusForbidden,
+				body:   `{"kind":"Status","code":403,"message":"forbidden is forbidden"}`,
+			},
+		},
+	}
+	expectedError := CategoryError{
+		Group:                "example.com",
+		Version:              "v1",
+		Resource:             "forbidden",
+		CategoryResultStatus: CategoryResultStatus{Status: http.StatusForbidden, Message: "forbidden is forbidden"},
+	}
+
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		jq          string
+	}{
+		{name: "default"},
+		{name: "conditional", ifNoneMatch: `"other"`},
+		{name: "jq", jq: "."},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := newMcpRequest("/c/test")
+			if test.ifNoneMatch != "" {
+				req.Header.Set(ifNoneMatchHeader, test.ifNoneMatch)
+			}
+			if test.jq != "" {
+				req.Header.Set(jqHeader, test.jq)
+			}
+			rec := httptest.NewRecorder()
+			NewMiddleware(newCrateKube(), kube, Config{Category: testCategoryConfig, JQ: JQConfig{MaxExpressionLength: 500, ExecutionTimeout: time.Second, MaxResults: 100}}).ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected status 200 but got %d: %s", rec.Code, rec.Body.String())
+			}
+			if partial := rec.Header().Get(partialResponseHeader); partial != "true" {
+				t.Errorf("expected the header %s: true but got %q", partialResponseHeader, partial)
+			}
+			if trailer := rec.Header().Get("Trailer"); trailer != "" {
+				t.Errorf("expected no trailer but got %q", trailer)
+			}
+
+			var result CategoryResult
+			if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+				t.Fatalf("expected a CategoryResult but got: %v", err)
+			}
+			if len(result.Resources) != 2 || string(result.Resources[0].List) != `{"items":[{"metadata":{"name":"a"}}]}` {
+				t.Errorf("expected the list of the allowed resource but got %+v", result.Resources)
+			}
+			if len(result.Errors) != 1 || result.Errors[0] != expectedError {
+				t.Errorf("expected the error %+v but got %+v", expectedError, result.Errors)
+			}
+			if status := result.Resources[1].Error; status == nil || *status != expectedError.CategoryResultStatus {
+				t.Errorf("expected the error in the entry of the forbidden resource but got %+v", status)
+			}
+		})
+	}
+}
+
+func TestCategoryHandlerConditionalRequest(t *testing.T) {
+	loadTestKubeconfig(t)
+	kube := &fakeKube{
+		groups: testCategoryGroups("things"),
+		responses: map[string]fakeResponse{
+			"/apis/example.com/v1/things": {body: `{"items":[{"metadata":{"name":"a"}}]}`},
+		},
+	}
+	handler := NewMiddleware(newCrateKube(), kube, Config{Category: testCategoryConfig})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, newMcpRequest("/managed"))
+	etag := rec.Header().Get(etagHeader)
+	if rec.Code != http.StatusOK || etag == "" {
+		t.Fatalf("expected an ETag on the unconditional response but got %d and %v", rec.Code, rec.Header())
+	}
+
+	req := newMcpRequest("/managed")
+	req.Header.Set(ifNoneMatchHeader, etag)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("expected 304 without a body but got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestCategoryHandlerCompression(t *testing.T) {
+	loadTestKubeconfig(t)
+	kube := &fakeKube{
+		groups: testCategoryGroups("things"),
+		responses: map[string]fakeResponse{
+			"/apis/example.com/v1/things": {body: `{"items":[{"metadata":{"name":"a"}}]}`},
+		},
+	}
+
+	req := newMcpRequest("/managed")
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	NewMiddleware(newCrateKube(), kube, Config{Category: testCategoryConfig}).ServeHTTP(rec, req)
+	if encoding := rec.Header().Get("Content-Encoding"); rec.Code != http.StatusOK || encoding != encodingGzip {
+		t.Fatalf("expected a gzip compressed response but got %d with Content-Encoding %q", rec.Code, encoding)
+	}
+
+	reader, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("expected a gzip body but got: %v", err)
+	}
+	var result CategoryResult
+	if err := json.NewDecoder(reader).Decode(&result); err != nil || len(result.Resources) != 1 {
+		t.Errorf("expected the CategoryResult but got %+v and %v", result, err)
+	}
+}
+
+func TestWriteCategoryResults(t *testing.T) {
+	resources := []categoryResource{
+		{group: "", version: "v1", resource: "configmaps", kind: "ConfigMap"},
+		{group: "example.com", version: "v1", resource: "things", kind: "Thing"},
+		{group: "example.com", version: "v1", resource: "others", kind: "Other"},
+	}
+
+	tests := []struct {
+		name     string
+		results  []categoryResourceResult
+		flatten  bool
+		expected string
+	}{
+		{
+			name: "lists",
+			results: []categoryResourceResult{
+				{body: []byte(`{"items":[{"metadata":{"name":"a"}}]}`)},
+				{body: []byte(`{"items":[]}`)},
+				{err: k8s.NewStatusError(http.StatusForbidden, []byte(`{"kind":"Status","message":"forbidden"}`))},
+			},
+			expected: `{"resources":[` +
+				`{"group":"","version":"v1","resource":"configmaps","kind":"ConfigMap","list":{"items":[{"metadata":{"name":"a"}}]}},` +
+				`{"group":"example.com","version":"v1","resource":"things","kind":"Thing","list":{"items":[]}},` +
+				`{"group":"example.com","version":"v1","resource":"others","kind":"Other","error":{"status":403,"message":"forbidden"}}` +
+				`],"errors":[{"group":"example.com","version":"v1","resource":"others","status":403,"message":"forbidden"}]}`,
+		},
+		{
+			name:    "flattened",
+			flatten: true,
+			results: []categoryResourceResult{
+				{body: []byte(`{"items":[{"metadata":{"name":"a"}}]}`)},
+				{body: []byte(`{"items":[{"apiVersion":"example.com/v2","kind":"Thing","metadata":{"name":"b"}},{"metadata":{"name":"c"}}]}`)},
+				{err: k8s.NewStatusError(http.StatusForbidden, []byte(`{"kind":"Status","message":"forbidden"}`))},
+			},
+			expected: `{"items":[` +
+				`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"a"}},` +
+				`{"apiVersion":"example.com/v2","kind":"Thing","metadata":{"name":"b"}},` +
+				`{"apiVersion":"example.com/v1","kind":"Thing","metadata":{"name":"c"}}` +
+				`],"errors":[{"group":"example.com","version":"v1","resource":"others","status":403,"message":"forbidden"}]}`,
+		},
+		{
+			name:    "flattened invalid list",
+			flatten: true,
+			results: []categoryResourceResult{
+				{body: []byte(`{"items":[]}`)},
+				{body: []byte(`{"items":`)},
+				{body: []byte(`{"items":[]}`)},
+			},
+			expected: `{"items":[],"errors":[{"group":"example.com","version":"v1","resource":"things","status":502,"message":"failed to decode list: unexpected end of JSON input"}]}`,
+		},
+		{
+			name: "timeout",
+			results: []categoryResourceResult{
+				{body: []byte(`{"items":[]}`)},
+				{body: []byte(`{"items":[]}`)},
+				{err: errCategoryResourceTimeout},
+			},
+			expected: `{"resources":[` +
+				`{"group":"","version":"v1","resource":"configmaps","kind":"ConfigMap","list":{"items":[]}},` +
+				`{"group":"example.com","version":"v1","resource":"things","kind":"Thing","list":{"items":[]}},` +
+				`{"group":"example.com","version":"v1","resource":"others","kind":"Other","error":{"status":504,"message":"request timed out"}}` +
+				`],"errors":[{"group":"example.com","version":"v1","resource":"others","status":504,"message":"request timed out"}]}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			results := make([]chan categoryResourceResult, len(test.results))
+			for i, result := range test.results {
+				results[i] = make(chan categoryResourceResult, 1)
+				results[i] <- result
+			}
+
+			var buf bytes.Buffer
+			if _, err := writeCategoryResults(&buf, resources, results, test.flatten); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if actual := buf.String(); actual != test.expected {
+				t.Errorf("expected\n%s\nbut got\n%s", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestCategoryHandlerFlatten(t *testing.T) {
+	loadTestKubeconfig(t)
+	kube := &fakeKube{
+		groups: testCategoryGroups("things"),
+		responses: map[string]fakeResponse{
+			"/apis/example.com/v1/things": {body: `{"items":[{"metadata":{"name":"a"}}]}`},
+		},
+	}
+
+	result, _ := requestCategory(t, kube, testCategoryConfig, "/c/test?flatten=true")
+	if len(result.Resources) != 0 || len(result.Items) != 1 {
+		t.Fatalf("expected only items but got %+v", result)
+	}
+	if actual := string(result.Items[0]); actual != `{"apiVersion":"example.com/v1","kind":"things","metadata":{"name":"a"}}` {
+		t.Errorf("expected the item with apiVersion and kind but got %s", actual)
+	}
+}
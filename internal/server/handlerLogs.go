@@ -0,0 +1,317 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/openmcp-project/ui-backend/pkg/k8s"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// maxLogStreams limits the number of pod/container log streams a single request can open.
+const maxLogStreams = 50
+
+// logOptionParameters are the query parameters passed through to the pods/log subresource.
+var logOptionParameters = []string{"follow", "sinceSeconds", "sinceTime", "tailLines", "timestamps", "previous", "limitBytes"}
+
+type logPod struct {
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Spec struct {
+		Containers []struct {
+			Name string `json:"name"`
+		} `json:"containers"`
+	} `json:"spec"`
+}
+
+type logPodList struct {
+	Items []logPod `json:"items"`
+}
+
+// logStream is the log of a single container.
+type logStream struct {
+	pod       string
+	container string
+	body      io.ReadCloser
+}
+
+func (l logStream) prefix() string {
+	return fmt.Sprintf("[pod/%s/%s] ", l.pod, l.container)
+}
+
+// logsHandler streams the logs of one pod (?pod=name) or of all pods matching a label selector (?labelSelector=app=foo)
+// in a namespace (?namespace=ns). Without ?container=name all containers of the pods are included. If more than one
+// container is streamed, every line is prefixed with [pod/<pod>/<container>] like kubectl does.
+func logsHandler(s *shared, req *http.Request, res *response) (*response, *HttpError) {
+	data, err := extractRequestData(req)
+	if err != nil {
+		return nil, NewBadRequestError("invalid request")
+	}
+
+	DeleteMultiple(data.Headers, prohibitedRequestHeaders)
+
+	namespace := data.Query.Get("namespace")
+	podName := data.Query.Get("pod")
+	labelSelector := data.Query.Get("labelSelector")
+	if namespace == "" {
+		return nil, NewBadRequestError("namespace not provided")
+	}
+	if (podName == "") == (labelSelector == "") {
+		return nil, NewBadRequestError("either pod or labelSelector has to be provided")
+	}
+	// the names are part of the request paths to the api server
+	if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+		return nil, NewBadRequestError("invalid namespace: %s", strings.Join(errs, ", "))
+	}
+	if podName != "" {
+		if errs := validation.IsDNS1123Subdomain(podName); len(errs) > 0 {
+			return nil, NewBadRequestError("invalid pod: %s", strings.Join(errs, ", "))
+		}
+	}
+	if labelSelector != "" {
+		if _, err := labels.Parse(labelSelector); err != nil {
+			return nil, NewBadRequestError("invalid labelSelector: %s", err)
+		}
+	}
+
+	logOptions := url.Values{}
+	for _, parameter := range logOptionParameters {
+		if value := data.Query.Get(parameter); value != "" {
+			logOptions.Set(parameter, value)
+		}
+	}
+	for _, parameter := range []string{"sinceSeconds", "tailLines", "limitBytes"} {
+		if value := logOptions.Get(parameter); value != "" {
+			if i, err := strconv.ParseInt(value, 10, 64); err != nil || i < 0 {
+				return nil, NewBadRequestError("%s has to be a non-negative integer", parameter)
+			}
+		}
+	}
+
+	config, httpErr := s.resolveKubeconfig(req.Context(), data, true)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	res.AddHeader("X-Response-From-Controlplane", "true")
+
+	containers := data.Query["container"]
+	var pods []logPod
+	if podName != "" && len(containers) > 0 {
+		// the containers are known already, so there is no need to get the pod
+		pod := logPod{}
+		pod.Metadata.Name = podName
+		pods = append(pods, pod)
+	} else {
+		pods, httpErr = s.getLogPods(req.Context(), config, namespace, podName, labelSelector)
+		if httpErr != nil {
+			return nil, httpErr
+		}
+	}
+
+	var targets []logStream
+	for _, pod := range pods {
+		containers := containers
+		if len(containers) == 0 {
+			for _, container := range pod.Spec.Containers {
+				containers = append(containers, container.Name)
+			}
+		}
+		for _, container := range containers {
+			targets = append(targets, logStream{pod: pod.Metadata.Name, container: container})
+		}
+	}
+	if len(targets) == 0 {
+		return nil, NewNotFoundError("no pods found")
+	}
+	if len(targets) > maxLogStreams {
+		return nil, NewBadRequestError("too many log streams (%d), at most %d are allowed", len(targets), maxLogStreams)
+	}
+
+	streams := make([]logStream, len(targets))
+	errs := make([]error, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			streams[i], errs[i] = s.openLogStream(req.Context(), config, namespace, target, logOptions)
+		}()
+	}
+	wg.Wait()
+
+	// a single stream behaves like the pods/log subresource of the api server
+	if len(streams) == 1 && errs[0] != nil {
+		var statusErr *k8s.StatusError
+		if errors.As(errs[0], &statusErr) {
+			return nil, NewHttpError(statusErr.Code, "%s", statusErr.Message)
+		}
+		slog.Error("failed to request logs", "err", errs[0])
+		return nil, NewHttpError(http.StatusBadGateway, "failed to make request to the api server")
+	}
+
+	res.contentType = "text/plain; charset=utf-8"
+	res.AddHeader("Cache-Control", "no-cache")
+	res.AddHeader("X-Accel-Buffering", "no")
+	res.stream = func(w http.ResponseWriter, req *http.Request) error {
+		return writeLogStreams(w, req, streams, errs)
+	}
+
+	return res, nil
+}
+
+func (s *shared) getLogPods(ctx context.Context, config k8s.KubeConfig, namespace, podName, labelSelector string) ([]logPod, *HttpError) {
+	var pods []logPod
+	var err error
+	if podName != "" {
+		pod := logPod{}
+		err = k8s.RequestApiServer(ctx, s.downstreamKube, k8s.Request{
+			Method: "GET",
+			Path:   fmt.Sprintf("/api/v1/namespaces/%s/pods/%s", namespace, podName),
+		}, config, &pod)
+		pods = append(pods, pod)
+	} else {
+		podList := logPodList{}
+		err = k8s.RequestApiServer(ctx, s.downstreamKube, k8s.Request{
+			Method: "GET",
+			Path:   fmt.Sprintf("/api/v1/namespaces/%s/pods", namespace),
+			Query:  url.Values{"labelSelector": {labelSelector}},
+		}, config, &podList)
+		pods = podList.Items
+	}
+
+	if err != nil {
+		var statusErr *k8s.StatusError
+		if errors.As(err, &statusErr) {
+			return nil, NewHttpError(statusErr.Code, "%s", statusErr.Message)
+		}
+		slog.Error("failed to get pods", "err", err)
+		return nil, NewHttpError(http.StatusBadGateway, "failed to make request to the api server")
+	}
+
+	return pods, nil
+}
+
+func (s *shared) openLogStream(ctx context.Context, config k8s.KubeConfig, namespace string, target logStream, logOptions url.Values) (logStream, error) {
+	query := url.Values{"container": {target.container}}
+	for k, v := range logOptions {
+		query[k] = v
+	}
+
+	k8sResp, err := s.downstreamKube.RequestApiServerRaw(ctx, k8s.Request{
+		Method: "GET",
+		Path:   fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/log", url.PathEscape(namespace), url.PathEscape(target.pod)),
+		Query:  query,
+	}, config)
+	if err != nil {
+		return target, err
+	}
+
+	if k8sResp.StatusCode >= 400 {
+		defer k8sResp.Body.Close()
+		body, _ := io.ReadAll(k8sResp.Body)
+		return target, k8s.NewStatusError(k8sResp.StatusCode, body)
+	}
+
+	target.body = k8sResp.Body
+	return target, nil
+}
+
+// writeLogStreams merges the log streams line by line into the response and flushes after every line.
+// Streams which failed to open are reported as a single line each.
+func writeLogStreams(w http.ResponseWriter, req *http.Request, streams []logStream, errs []error) error {
+	rc := http.NewResponseController(w)
+	multiplexed := len(streams) > 1
+
+	lines := make(chan string)
+	var wg sync.WaitGroup
+	for i, stream := range streams {
+		if errs[i] != nil {
+			message := errs[i].Error()
+			var statusErr *k8s.StatusError
+			if errors.As(errs[i], &statusErr) {
+				message = statusErr.Message
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				select {
+				case lines <- stream.prefix() + "failed to get logs: " + message + "\n":
+				case <-req.Context().Done():
+				}
+			}()
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			readLogLines(req, stream, multiplexed, lines)
+		}()
+	}
+
+	// closing the bodies unblocks the readers when the client goes away
+	go func() {
+		<-req.Context().Done()
+		for _, stream := range streams {
+			if stream.body != nil {
+				_ = stream.body.Close()
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(lines)
+	}()
+
+	for line := range lines {
+		if _, err := io.WriteString(w, line); err != nil {
+			return err
+		}
+		if err := rc.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readLogLines(req *http.Request, stream logStream, prefixed bool, lines chan<- string) {
+	defer func() {
+		_ = stream.body.Close()
+	}()
+
+	reader := bufio.NewReader(stream.body)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			if !strings.HasSuffix(line, "\n") {
+				line += "\n"
+			}
+			if prefixed {
+				line = stream.prefix() + line
+			}
+			select {
+			case lines <- line:
+			case <-req.Context().Done():
+				return
+			}
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && req.Context().Err() == nil {
+				slog.Error("failed to read logs", "pod", stream.pod, "container", stream.container, "err", err)
+			}
+			return
+		}
+	}
+}
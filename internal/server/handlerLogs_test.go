@@ -0,0 +1,103 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLogsHandler(t *testing.T) {
+	loadTestKubeconfig(t)
+	kube := &fakeKube{responses: map[string]fakeResponse{
+		"/api/v1/namespaces/default/pods": {body: `{"items":[
+			{"metadata":{"name":"a"},"spec":{"containers":[{"name":"app"}]}},
+			{"metadata":{"name":"b"},"spec":{"containers":[{"name":"app"},{"name":"sidecar"}]}}
+		]}`},
+		"/api/v1/namespaces/default/pods/a":           {body: `{"metadata":{"name":"a"},"spec":{"containers":[{"name":"app"}]}}`},
+		"/api/v1/namespaces/default/pods/a/log":       {body: "first\nsecond"},
+		"/api/v1/namespaces/default/pods/b/log":       {body: "third\n"},
+		"/api/v1/namespaces/default/pods/missing/log": {status: http.StatusNotFound, body: `{"kind":"Status","code":404,"message":"pods \"missing\" not found"}`},
+	}}
+	handler := NewMiddleware(kube, kube, Config{})
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		// expectedLines are compared sorted, as the lines of multiplexed streams are interleaved
+		expectedLines []string
+	}{
+		{
+			name:           "single pod isn't prefixed",
+			query:          "namespace=default&pod=a",
+			expectedStatus: http.StatusOK,
+			expectedLines:  []string{"first", "second"},
+		},
+		{
+			name:           "label selector multiplexes and prefixes",
+			query:          "namespace=default&labelSelector=app%3Dfoo",
+			expectedStatus: http.StatusOK,
+			expectedLines: []string{
+				"[pod/a/app] first",
+				"[pod/a/app] second",
+				"[pod/b/app] third",
+				"[pod/b/sidecar] third",
+			},
+		},
+		{
+			name:           "failed stream of a multiplexed request is reported inline",
+			query:          "namespace=default&pod=missing&container=app&container=sidecar",
+			expectedStatus: http.StatusOK,
+			expectedLines: []string{
+				`[pod/missing/app] failed to get logs: pods "missing" not found`,
+				`[pod/missing/sidecar] failed to get logs: pods "missing" not found`,
+			},
+		},
+		{
+			name:           "single failed stream returns the status of the api server",
+			query:          "namespace=default&pod=missing&container=app",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "namespace with a path",
+			query:          "namespace=default%2Fpods%2Fa%2F..&pod=a",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "pod with a path",
+			query:          "namespace=default&pod=..%2F..%2Fsecrets",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "invalid label selector",
+			query:          "namespace=default&labelSelector=app+in+(",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "pod and label selector",
+			query:          "namespace=default&pod=a&labelSelector=app%3Dfoo",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, newCrateRequest("/logs?"+test.query))
+
+			if rec.Code != test.expectedStatus {
+				t.Fatalf("expected status %d but got %d: %s", test.expectedStatus, rec.Code, rec.Body.String())
+			}
+			if test.expectedStatus != http.StatusOK {
+				return
+			}
+			lines := strings.Split(strings.TrimSuffix(rec.Body.String(), "\n"), "\n")
+			slices.Sort(lines)
+			if !slices.Equal(lines, test.expectedLines) {
+				t.Errorf("expected lines %q but got %q", test.expectedLines, lines)
+			}
+		})
+	}
+}
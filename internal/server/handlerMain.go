@@ -1,6 +1,8 @@
 package server
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"errors"
 	"fmt"
@@ -8,6 +10,7 @@ import (
 	"log/slog"
 	"net/http"
 	"net/url"
+	"slices"
 	"strconv"
 
 	"github.com/openmcp-project/ui-backend/internal/utils"
@@ -25,6 +28,7 @@ const (
 	useCrateClusterHeader                 = "X-use-crate"
 	authorizationHeader                   = "Authorization"
 	jqHeader                              = "X-jq"
+	jsonPathHeader                        = "X-jsonpath"
 	categoryHeader                        = "X-category"
 )
 
@@ -68,6 +72,7 @@ type ExtractedRequestData struct {
 	McpAuthorizationToken           string
 	Headers                         map[string][]string
 	JQ                              string
+	JsonPath                        string
 	Category                        string
 }
 
@@ -79,8 +84,21 @@ func mainHandler(s *shared, req *http.Request, res *response) (*response, *HttpE
 		return nil, NewBadRequestError("invalid request")
 	}
 
+	acceptEncoding := req.Header.Get("Accept-Encoding")
 	DeleteMultiple(data.Headers, prohibitedRequestHeaders)
 
+	var filter eventFilter
+	watch := isWatchRequest(data)
+	if watch {
+		prepareWatchRequest(req, &data)
+		if filter, err = s.watchEventFilter(data); err != nil {
+			return nil, NewBadRequestError("%v", err)
+		}
+	} else if s.compressionConfig.UpstreamGzip && !isFollowLogRequest(data) {
+		// setting the header explicitly keeps the transport from decompressing the response
+		data.Headers["Accept-Encoding"] = []string{encodingGzip}
+	}
+
 	apiReq := k8s.Request{
 		Method:  data.Method,
 		Path:    data.Path,
@@ -89,45 +107,35 @@ func mainHandler(s *shared, req *http.Request, res *response) (*response, *HttpE
 		Headers: data.Headers,
 	}
 
-	crateKubeconfig, ok := utils.GetCrateKubeconfig()
-	if !ok {
-		slog.Error("failed to get crate kubeconfig")
-		return nil, NewInternalServerError("failed to get crate kubeconfig")
+	config, serviceConfig, httpErr := s.resolveKubeconfigs(req.Context(), data, true)
+	if httpErr != nil {
+		return nil, httpErr
 	}
+	kube := k8s.WithInformers(s.downstreamKube, s.informers, serviceConfig)
 
-	var config k8s.KubeConfig
-	if data.UseCrateCluster {
-		config = crateKubeconfig
-		config.SetUserToken(data.CrateAuthorizationToken)
-	} else if data.ProjectName != "" && data.WorkspaceName != "" && data.McpName != "" {
-		config, err = openmcp.GetControlPlaneKubeconfig(s.crateKube, data.ProjectName, data.WorkspaceName, data.McpName, data.CrateAuthorizationToken, crateKubeconfig)
-		if err != nil {
-			slog.Error("failed to get control plane api config", "err", err)
-			return nil, NewInternalServerError("failed to get control plane api config")
-		}
-		if data.McpAuthorizationToken == "" {
-			slog.Error("MCP authorization token not provided")
-			return nil, NewBadRequestError("MCP authorization token not provided")
+	res.AddHeader("X-Response-From-Controlplane", "true")
+
+	// if the resourceVersion of a list didn't change, the list doesn't have to be requested
+	listVariant, listETagAllowed := listETagVariant(req, data, config)
+	if ifNoneMatch := req.Header.Values(ifNoneMatchHeader); listETagAllowed && hasListETag(ifNoneMatch) {
+		if etag, ok := s.checkListVersion(req.Context(), apiReq, config, listVariant, ifNoneMatch); ok {
+			res.statusCode = http.StatusNotModified
+			res.AddHeader(etagHeader, etag)
+			return res, nil
 		}
-		config.SetUserToken(data.McpAuthorizationToken)
-	} else {
-		slog.Error("either use %s: true or provide %s, %s and %s headers", useCrateClusterHeader, projectNameHeader, workspaceNameHeader, mcpName)
-		return nil, NewBadRequestError(
-			"either use %s: true or provide %s, %s and %s headers",
-			useCrateClusterHeader,
-			projectNameHeader,
-			workspaceNameHeader,
-			mcpName,
-		)
 	}
 
-	res.AddHeader("X-Response-From-Controlplane", "true")
-
-	k8sResp, err := s.downstreamKube.RequestApiServerRaw(apiReq, config)
+	k8sResp, err := kube.RequestApiServerRaw(req.Context(), apiReq, config)
 	if err != nil {
 		slog.Error("failed to make request to the api server", "err", err)
 		return nil, NewHttpError(http.StatusBadGateway, "failed to make request to the api server")
 	}
+
+	if (watch || isFollowLogRequest(data)) && k8sResp.StatusCode < 400 {
+		res.buildStreamResponse(k8sResp, wantsEventStream(req), filter)
+		return res, nil
+	}
+
 	defer func(Body io.ReadCloser) {
 		errC := Body.Close()
 		if errC != nil {
@@ -135,12 +143,42 @@ func mainHandler(s *shared, req *http.Request, res *response) (*response, *HttpE
 		}
 	}(k8sResp.Body)
 
-	if (data.JQ == "") || k8sResp.StatusCode >= 400 {
+	gzipped := k8sResp.Header.Get("Content-Encoding") == encodingGzip
+	if gzipped && (data.JQ != "" || data.JsonPath != "" || !acceptsEncoding(acceptEncoding, encodingGzip)) {
+		body, err := newGzipReadCloser(k8sResp.Body)
+		if err != nil {
+			return nil, NewInternalServerError("failed to decompress api server response: %v", err)
+		}
+		k8sResp.Body = body
+		k8sResp.Header.Del("Content-Encoding")
+		k8sResp.Header.Del("Content-Length")
+		gzipped = false
+	}
+
+	var listResourceVersionFound bool
+	var resourceVersion string
+	if listETagAllowed {
+		body, err := io.ReadAll(k8sResp.Body)
+		if err != nil {
+			return nil, NewInternalServerError("failed to read api server response: %v", err)
+		}
+		k8sResp.Body = io.NopCloser(bytes.NewReader(body))
+
+		var listBody io.Reader = bytes.NewReader(body)
+		if gzipped {
+			if listBody, err = gzip.NewReader(listBody); err != nil {
+				return nil, NewInternalServerError("failed to decompress api server response: %v", err)
+			}
+		}
+		resourceVersion, listResourceVersionFound = listResourceVersion(k8sResp, listBody)
+	}
+
+	if (data.JQ == "" && data.JsonPath == "") || k8sResp.StatusCode >= 400 {
 		err = CopyResponse(res, k8sResp, nil, nil)
 		if err != nil {
 			return nil, NewInternalServerError("failed to copy response: %v", err)
 		}
-	} else {
+	} else if data.JQ != "" {
 		if len(data.JQ) > s.jqConfig.MaxExpressionLength {
 			return nil, NewBadRequestError("jq expression exceeds maximum allowed length")
 		}
@@ -148,11 +186,76 @@ func mainHandler(s *shared, req *http.Request, res *response) (*response, *HttpE
 		if err != nil {
 			return nil, NewInternalServerError("failed to build jq response: %v", err)
 		}
+	} else {
+		if len(data.JsonPath) > s.jsonPathConfig.MaxExpressionLength {
+			return nil, NewBadRequestError("jsonpath expression exceeds maximum allowed length")
+		}
+		err := res.buildJsonPathResponse(req.Context(), k8sResp, data, s.jsonPathConfig)
+		if err != nil {
+			return nil, NewInternalServerError("failed to build jsonpath response: %v", err)
+		}
+	}
+
+	if listResourceVersionFound {
+		res.AddHeader(etagHeader, listETag(listVariant, resourceVersion, res.body))
 	}
 
 	return res, nil
 }
 
+// resolveKubeconfig returns the kubeconfig of the cluster the request is targeted at, authenticated with the token of the caller.
+// Requests either go to the crate cluster (if allowed) or to the MCP identified by the project, workspace and mcp headers.
+func (s *shared) resolveKubeconfig(ctx context.Context, data ExtractedRequestData, allowCrate bool) (k8s.KubeConfig, *HttpError) {
+	config, _, err := s.resolveKubeconfigs(ctx, data, allowCrate)
+	return config, err
+}
+
+// resolveKubeconfigs is like resolveKubeconfig, but additionally returns the kubeconfig of the cluster with the credentials
+// of the service, which is used for the informers.
+func (s *shared) resolveKubeconfigs(ctx context.Context, data ExtractedRequestData, allowCrate bool) (k8s.KubeConfig, k8s.KubeConfig, *HttpError) {
+	ctx, span := tracer.Start(ctx, "resolveKubeconfig")
+	defer span.End()
+
+	crateKubeconfig, ok := utils.GetCrateKubeconfig()
+	if !ok {
+		slog.Error("failed to get crate kubeconfig")
+		return k8s.KubeConfig{}, k8s.KubeConfig{}, NewInternalServerError("failed to get crate kubeconfig")
+	}
+
+	// SetUserToken modifies the users in place, so they are copied first
+	if allowCrate && data.UseCrateCluster {
+		config := crateKubeconfig
+		config.Users = slices.Clone(crateKubeconfig.Users)
+		config.SetUserToken(data.CrateAuthorizationToken)
+		return config, crateKubeconfig, nil
+	}
+
+	if data.ProjectName != "" && data.WorkspaceName != "" && data.McpName != "" {
+		serviceConfig, err := openmcp.GetControlPlaneKubeconfig(ctx, s.crateKube, data.ProjectName, data.WorkspaceName, data.McpName, data.CrateAuthorizationToken, crateKubeconfig)
+		if err != nil {
+			slog.Error("failed to get control plane api config", "err", err)
+			return k8s.KubeConfig{}, k8s.KubeConfig{}, NewInternalServerError("failed to get control plane api config")
+		}
+		if data.McpAuthorizationToken == "" {
+			slog.Error("MCP authorization token not provided")
+			return k8s.KubeConfig{}, k8s.KubeConfig{}, NewBadRequestError("MCP authorization token not provided")
+		}
+		config := serviceConfig
+		config.Users = slices.Clone(serviceConfig.Users)
+		config.SetUserToken(data.McpAuthorizationToken)
+		return config, serviceConfig, nil
+	}
+
+	slog.Error("either use %s: true or provide %s, %s and %s headers", useCrateClusterHeader, projectNameHeader, workspaceNameHeader, mcpName)
+	return k8s.KubeConfig{}, k8s.KubeConfig{}, NewBadRequestError(
+		"either use %s: true or provide %s, %s and %s headers",
+		useCrateClusterHeader,
+		projectNameHeader,
+		workspaceNameHeader,
+		mcpName,
+	)
+}
+
 func extractRequestData(r *http.Request) (ExtractedRequestData, error) {
 	if r.Header.Get(authorizationHeader) == "" {
 		return ExtractedRequestData{}, fmt.Errorf("%s header is required", authorizationHeader)
@@ -177,9 +280,14 @@ func extractRequestData(r *http.Request) (ExtractedRequestData, error) {
 		CrateAuthorizationToken:         crateToken,
 		McpAuthorizationToken:           mcpToken,
 		JQ:                              r.Header.Get(jqHeader),
+		JsonPath:                        r.Header.Get(jsonPathHeader),
 		Category:                        r.Header.Get(categoryHeader),
 	}
 
+	if rd.JQ != "" && rd.JsonPath != "" {
+		return ExtractedRequestData{}, fmt.Errorf("%s and %s can't be used together", jqHeader, jsonPathHeader)
+	}
+
 	rd.Headers = r.Header
 
 	if cc := r.Header.Get(useCrateClusterHeader); cc != "" {
@@ -200,7 +308,9 @@ func (r *response) buildJqResponse(parentCtx context.Context, k8sResp *http.Resp
 		return errors.Join(errors.New("failed to read api server response"), err)
 	}
 
-	ctx, cancel := context.WithTimeout(parentCtx, jqConfig.ExecutionTimeout)
+	ctx, span := tracer.Start(parentCtx, "jq")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, jqConfig.ExecutionTimeout)
 	defer cancel()
 
 	parsedJson, err := ParseJQ(ctx, body, data.JQ, jqConfig.MaxResults)
@@ -214,3 +324,26 @@ func (r *response) buildJqResponse(parentCtx context.Context, k8sResp *http.Resp
 	r.contentType = "application/json"
 	return err
 }
+
+func (r *response) buildJsonPathResponse(parentCtx context.Context, k8sResp *http.Response, data ExtractedRequestData, jsonPathConfig JsonPathConfig) error {
+	body, err := io.ReadAll(k8sResp.Body)
+	if err != nil {
+		return errors.Join(errors.New("failed to read api server response"), err)
+	}
+
+	ctx, span := tracer.Start(parentCtx, "jsonpath")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, jsonPathConfig.ExecutionTimeout)
+	defer cancel()
+
+	output, err := ParseJsonPath(ctx, body, data.JsonPath, jsonPathConfig.MaxOutputSize)
+	if err != nil {
+		slog.Error("jsonpath execution failed", "err", err)
+		return fmt.Errorf("failed to process jsonpath expression")
+	}
+
+	err = CopyResponse(r, k8sResp, []byte(output), prohibitedResponseHeaders)
+
+	r.contentType = "text/plain; charset=utf-8"
+	return err
+}
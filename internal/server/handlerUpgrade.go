@@ -0,0 +1,173 @@
+package server
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/openmcp-project/ui-backend/pkg/k8s"
+)
+
+const (
+	upgradePathPrefix = "/ws"
+	// bearerProtocolPrefix is the websocket subprotocol kubernetes uses to pass a bearer token from browsers,
+	// which can't set the Authorization header on websocket connections.
+	bearerProtocolPrefix    = "base64url.bearer.authorization.k8s.io."
+	webSocketProtocolHeader = "Sec-WebSocket-Protocol"
+)
+
+// upgradeQueryParameters maps query parameters to the headers they replace for clients which can't set headers.
+var upgradeQueryParameters = map[string]string{
+	"project":   projectNameHeader,
+	"workspace": workspaceNameHeader,
+	"mcp":       mcpName,
+	"use-crate": useCrateClusterHeader,
+}
+
+// upgradeSubresourcePath matches the pod subresources which require a connection upgrade.
+var upgradeSubresourcePath = regexp.MustCompile(`^/api/v1/namespaces/[^/]+/pods/[^/]+/(exec|attach|portforward)$`)
+
+// upgradeHandler bridges websocket connections (e.g. using the v5.channel.k8s.io subprotocol) for pods/exec, pods/attach and
+// pods/portforward to the api server. The api server path is expected after the /ws prefix,
+// e.g. /ws/api/v1/namespaces/default/pods/my-pod/exec?command=sh&stdin=true&stdout=true&tty=true&project=a&workspace=b&mcp=c
+func upgradeHandler(s *shared) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !isUpgradeRequest(req) {
+			writeError(w, NewBadRequestError("only websocket upgrade requests are supported"))
+			return
+		}
+		// websockets aren't subject to CORS, so the browser would open connections from any origin
+		if origin := req.Header.Get("Origin"); origin != "" && !s.corsConfig.originAllowed(origin) {
+			writeError(w, NewHttpError(http.StatusForbidden, "origin %s is not allowed", origin))
+			return
+		}
+
+		path := strings.TrimPrefix(req.URL.Path, upgradePathPrefix)
+		if !upgradeSubresourcePath.MatchString(path) {
+			writeError(w, NewNotFoundError("only pods/exec, pods/attach and pods/portforward can be upgraded"))
+			return
+		}
+
+		if err := moveUpgradeCredentialsToHeaders(req); err != nil {
+			writeError(w, NewBadRequestError("invalid request: %v", err))
+			return
+		}
+
+		data, err := extractRequestData(req)
+		if err != nil {
+			writeError(w, NewBadRequestError("invalid request"))
+			return
+		}
+
+		config, httpErr := s.resolveKubeconfig(req.Context(), data, true)
+		if httpErr != nil {
+			writeError(w, httpErr)
+			return
+		}
+
+		proxy, err := newUpgradeProxy(config, path)
+		if err != nil {
+			slog.Error("failed to create upgrade proxy", "err", err)
+			writeError(w, NewInternalServerError("failed to create upgrade proxy"))
+			return
+		}
+
+		slog.Debug("upgrading connection", "host", config.Clusters[0].Cluster.Server, "path", path)
+		proxy.ServeHTTP(w, req)
+	}
+}
+
+func isUpgradeRequest(req *http.Request) bool {
+	for _, connection := range req.Header.Values("Connection") {
+		for _, token := range strings.Split(connection, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return strings.EqualFold(req.Header.Get("Upgrade"), "websocket")
+			}
+		}
+	}
+	return false
+}
+
+// moveUpgradeCredentialsToHeaders moves the bearer token subprotocol and the target cluster query parameters into the headers
+// the other handlers use, and removes them from the request, so they aren't forwarded to the api server.
+func moveUpgradeCredentialsToHeaders(req *http.Request) error {
+	var protocols []string
+	for _, value := range req.Header.Values(webSocketProtocolHeader) {
+		for _, protocol := range strings.Split(value, ",") {
+			protocol = strings.TrimSpace(protocol)
+			if encoded, ok := strings.CutPrefix(protocol, bearerProtocolPrefix); ok {
+				token, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+				if err != nil {
+					return fmt.Errorf("invalid bearer token subprotocol: %w", err)
+				}
+				req.Header.Set(authorizationHeader, string(token))
+				continue
+			}
+			if protocol != "" {
+				protocols = append(protocols, protocol)
+			}
+		}
+	}
+	req.Header.Del(webSocketProtocolHeader)
+	if len(protocols) > 0 {
+		req.Header.Set(webSocketProtocolHeader, strings.Join(protocols, ", "))
+	}
+
+	query := req.URL.Query()
+	for parameter, header := range upgradeQueryParameters {
+		if value := query.Get(parameter); value != "" && req.Header.Get(header) == "" {
+			req.Header.Set(header, value)
+		}
+		query.Del(parameter)
+	}
+	req.URL.RawQuery = query.Encode()
+
+	return nil
+}
+
+// newUpgradeProxy creates a reverse proxy to the api server. The transport is restricted to HTTP/1.1, because
+// connection upgrades aren't possible with HTTP/2.
+func newUpgradeProxy(config k8s.KubeConfig, path string) (*httputil.ReverseProxy, error) {
+	tlsConfig, err := k8s.NewTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := url.Parse(config.Clusters[0].Cluster.Server)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse url: %v", err)
+	}
+	token := config.Users[0].User.Token
+
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.URL.Scheme = target.Scheme
+			pr.Out.URL.Host = target.Host
+			pr.Out.URL.Path = strings.TrimSuffix(target.Path, "/") + path
+			pr.Out.URL.RawPath = ""
+			pr.Out.Host = target.Host
+
+			for _, header := range prohibitedRequestHeaders {
+				if header != "Connection" && header != "Upgrade" {
+					pr.Out.Header.Del(header)
+				}
+			}
+			pr.Out.Header.Del("Cookie")
+			if token != "" {
+				pr.Out.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+			}
+		},
+		Transport: &http.Transport{
+			TLSClientConfig: tlsConfig,
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			slog.Error("failed to proxy upgrade request", "err", err)
+			writeError(w, NewHttpError(http.StatusBadGateway, "failed to make request to the api server"))
+		},
+	}, nil
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMoveUpgradeCredentialsToHeaders(t *testing.T) {
+	token := base64.RawURLEncoding.EncodeToString([]byte("crate-token,mcp-token"))
+
+	tests := []struct {
+		name                  string
+		protocols             []string
+		query                 string
+		expectedAuthorization string
+		expectedProtocols     string
+		expectedQuery         string
+		expectErr             bool
+	}{
+		{
+			name:                  "bearer subprotocol becomes the Authorization header",
+			protocols:             []string{bearerProtocolPrefix + token + ", v5.channel.k8s.io"},
+			expectedAuthorization: "crate-token,mcp-token",
+			expectedProtocols:     "v5.channel.k8s.io",
+		},
+		{
+			name:                  "padded token in a separate header",
+			protocols:             []string{"v4.channel.k8s.io", bearerProtocolPrefix + base64.URLEncoding.EncodeToString([]byte("token"))},
+			expectedAuthorization: "token",
+			expectedProtocols:     "v4.channel.k8s.io",
+		},
+		{
+			name:              "other subprotocols pass through",
+			protocols:         []string{"v5.channel.k8s.io, v4.channel.k8s.io"},
+			expectedProtocols: "v5.channel.k8s.io, v4.channel.k8s.io",
+		},
+		{
+			name:          "cluster query parameters are removed",
+			query:         "command=sh&project=a&workspace=b&mcp=c&stdin=true",
+			expectedQuery: "command=sh&stdin=true",
+		},
+		{
+			name:      "invalid token",
+			protocols: []string{bearerProtocolPrefix + "not base64!"},
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/ws/api/v1/namespaces/default/pods/p/exec?"+test.query, nil)
+			for _, protocol := range test.protocols {
+				req.Header.Add(webSocketProtocolHeader, protocol)
+			}
+
+			err := moveUpgradeCredentialsToHeaders(req)
+			if test.expectErr {
+				if err == nil {
+					t.Errorf("expected an error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error but got: %v", err)
+			}
+			if actual := req.Header.Get(authorizationHeader); actual != test.expectedAuthorization {
+				t.Errorf("expected Authorization %q but got %q", test.expectedAuthorization, actual)
+			}
+			if actual := strings.Join(req.Header.Values(webSocketProtocolHeader), ", "); actual != test.expectedProtocols {
+				t.Errorf("expected subprotocols %q but got %q", test.expectedProtocols, actual)
+			}
+			if test.query != "" {
+				if actual := req.URL.RawQuery; actual != test.expectedQuery {
+					t.Errorf("expected query %q but got %q", test.expectedQuery, actual)
+				}
+				if req.Header.Get(projectNameHeader) != "a" || req.Header.Get(workspaceNameHeader) != "b" || req.Header.Get(mcpName) != "c" {
+					t.Errorf("expected the query parameters in the headers but got %v", req.Header)
+				}
+			}
+		})
+	}
+}
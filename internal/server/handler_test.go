@@ -0,0 +1,141 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/openmcp-project/ui-backend/internal/utils"
+	"github.com/openmcp-project/ui-backend/pkg/k8s"
+	"k8s.io/api/apidiscovery/v2beta1"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: crate
+  cluster:
+    server: https://crate.example.com
+users:
+- name: crate
+  user:
+    token: service-token
+`
+
+// fakeResponse is the answer of fakeKube to a request path.
+type fakeResponse struct {
+	// status defaults to 200
+	status int
+	body   string
+	// delay is waited before the response is sent, a canceled request doesn't wait
+	delay time.Duration
+	// release, if set, has to be closed before the response is sent
+	release chan struct{}
+}
+
+// fakeKube answers requests with the responses registered for their paths and counts the concurrent requests.
+type fakeKube struct {
+	responses map[string]fakeResponse
+	groups    []v2beta1.APIGroupDiscovery
+
+	inFlight    atomic.Int32
+	maxInFlight atomic.Int32
+}
+
+func (f *fakeKube) RequestApiServerRaw(ctx context.Context, request k8s.Request, _ k8s.KubeConfig) (*http.Response, error) {
+	inFlight := f.inFlight.Add(1)
+	defer f.inFlight.Add(-1)
+	for {
+		maxInFlight := f.maxInFlight.Load()
+		if inFlight <= maxInFlight || f.maxInFlight.CompareAndSwap(maxInFlight, inFlight) {
+			break
+		}
+	}
+
+	response, ok := f.responses[request.Path]
+	if !ok {
+		response = fakeResponse{status: http.StatusNotFound, body: `{"kind":"Status","code":404,"message":"not found"}`}
+	}
+	select {
+	case <-time.After(response.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if response.release != nil {
+		select {
+		case <-response.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	return &http.Response{
+		StatusCode: cmp.Or(response.status, http.StatusOK),
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(response.body)),
+	}, nil
+}
+
+func (f *fakeKube) RequestApiGroupsByCategory(_ context.Context, _ k8s.KubeConfig, _ string) ([]v2beta1.APIGroupDiscovery, error) {
+	return f.groups, nil
+}
+
+// loadTestKubeconfig loads a crate kubeconfig, which all handlers need to resolve the cluster of a request.
+func loadTestKubeconfig(t *testing.T) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	if err := os.WriteFile(path, []byte(testKubeconfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go utils.StartListeningOnKubeconfig(ctx, path)
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if _, ok := utils.GetCrateKubeconfig(); ok {
+			return
+		}
+	}
+	t.Fatal("crate kubeconfig wasn't loaded")
+}
+
+// newCrateKube returns a crate cluster with the control plane "mcp" in the workspace "ws" of the project "project".
+func newCrateKube() *fakeKube {
+	kubeconfig := base64.StdEncoding.EncodeToString([]byte(testKubeconfig))
+	return &fakeKube{responses: map[string]fakeResponse{
+		"/apis/core.openmcp.cloud/v1alpha1/namespaces/project-project--ws-ws/managedcontrolplanes/mcp": {
+			body: `{"status":{"components":{"authentication":{"access":{"key":"kubeconfig","name":"mcp-kubeconfig","namespace":"project-project--ws-ws"}}}}}`,
+		},
+		"api/v1/namespaces/project-project--ws-ws/secrets/mcp-kubeconfig": {
+			body: `{"data":{"kubeconfig":"` + kubeconfig + `"}}`,
+		},
+	}}
+}
+
+// newMcpRequest returns a request to the control plane of newCrateKube.
+func newMcpRequest(target string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.Header.Set(authorizationHeader, "crate-token,mcp-token")
+	req.Header.Set(projectNameHeader, "project")
+	req.Header.Set(workspaceNameHeader, "ws")
+	req.Header.Set(mcpName, "mcp")
+	return req
+}
+
+// newCrateRequest returns a request to the crate cluster.
+func newCrateRequest(target string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.Header.Set(authorizationHeader, "crate-token")
+	req.Header.Set(useCrateClusterHeader, "true")
+	return req
+}
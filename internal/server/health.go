@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/openmcp-project/ui-backend/internal/utils"
+	"github.com/openmcp-project/ui-backend/pkg/k8s"
+)
+
+// readinessProbeTimeout limits how long the readiness check waits for its probe.
+const readinessProbeTimeout = 5 * time.Second
+
+// Health tracks whether the server accepts new requests. During a shutdown, readiness fails before the server stops
+// accepting connections, so the load balancer stops sending requests first.
+type Health struct {
+	// probe checks whether the requests can be served, it is optional
+	probe    func(ctx context.Context) error
+	draining atomic.Bool
+	// streams is canceled when the streamed responses are ended
+	streams      context.Context
+	closeStreams context.CancelFunc
+}
+
+// NewHealth returns the health of the server. Readiness fails while the probe returns an error, e.g. CrateProbe.
+func NewHealth(probe func(ctx context.Context) error) *Health {
+	streams, closeStreams := context.WithCancel(context.Background())
+	return &Health{probe: probe, streams: streams, closeStreams: closeStreams}
+}
+
+// CrateProbe returns a probe which succeeds once the crate kubeconfig is loaded and the crate api server responds.
+// The request uses the service credentials of the user in the crate kubeconfig, not those of a client. Every response
+// below 500 shows the api server is available, even if the credentials are rejected.
+func CrateProbe(kube k8s.Kube) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		config, ok := utils.GetCrateKubeconfig()
+		if !ok {
+			if _, err := utils.CrateKubeconfigStatus(); err != nil {
+				return fmt.Errorf("crate kubeconfig isn't loaded: %w", err)
+			}
+			return errors.New("crate kubeconfig isn't loaded yet")
+		}
+
+		res, err := kube.RequestApiServerRaw(ctx, k8s.Request{Method: http.MethodGet, Path: "/version"}, config)
+		if err != nil {
+			return fmt.Errorf("crate api server isn't reachable: %w", err)
+		}
+		_, _ = io.Copy(io.Discard, res.Body)
+		_ = res.Body.Close()
+		if res.StatusCode >= http.StatusInternalServerError {
+			return fmt.Errorf("crate api server responded with %d", res.StatusCode)
+		}
+		return nil
+	}
+}
+
+// Drain lets the readiness check fail.
+func (h *Health) Drain() {
+	h.draining.Store(true)
+}
+
+// CloseStreams ends the streamed responses, e.g. watches, so their connections don't delay the shutdown. The clients
+// are expected to reconnect to another instance.
+func (h *Health) CloseStreams() {
+	h.closeStreams()
+}
+
+// streamContext returns a context which is canceled when the parent is done or the streams are closed.
+func (h *Health) streamContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	stop := context.AfterFunc(h.streams, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// liveHandler succeeds as long as the server handles requests at all. Problems of the crate don't fail it, as a restart
+// wouldn't fix them.
+func (h *Health) liveHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+func (h *Health) readyHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if h.draining.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("shutting down\n"))
+		return
+	}
+	if h.probe != nil {
+		ctx, cancel := context.WithTimeout(req.Context(), readinessProbeTimeout)
+		defer cancel()
+		if err := h.probe(ctx); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = fmt.Fprintf(w, "not ready: %v\n", err)
+			return
+		}
+	}
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// isStreamingRequest returns whether the response to the request is streamed, so it lasts until the client or the
+// api server ends it.
+func isStreamingRequest(handlerName string, req *http.Request) bool {
+	data := ExtractedRequestData{Path: req.URL.Path, Query: req.URL.Query()}
+	return handlerName == "logs" || isWatchRequest(data) || isFollowLogRequest(data)
+}
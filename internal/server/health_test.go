@@ -0,0 +1,44 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealth(t *testing.T) {
+	probeErr := errors.New("crate kubeconfig isn't loaded yet")
+	health := NewHealth(func(ctx context.Context) error { return probeErr })
+	ready := func() int {
+		rec := httptest.NewRecorder()
+		health.readyHandler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return rec.Code
+	}
+
+	streamCtx, cancel := health.streamContext(context.Background())
+	defer cancel()
+
+	if code := ready(); code != http.StatusServiceUnavailable {
+		t.Errorf("expected not ready while the probe fails but got %d", code)
+	}
+	probeErr = nil
+	if code := ready(); code != http.StatusOK {
+		t.Errorf("expected ready before the shutdown but got %d", code)
+	}
+	health.Drain()
+	if code := ready(); code != http.StatusServiceUnavailable {
+		t.Errorf("expected not ready while draining but got %d", code)
+	}
+	if streamCtx.Err() != nil {
+		t.Errorf("expected streams to stay open while draining")
+	}
+	health.CloseStreams()
+	select {
+	case <-streamCtx.Done():
+	case <-time.After(time.Second):
+		t.Errorf("expected streams to be closed")
+	}
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"runtime"
+	"time"
+
+	"github.com/openmcp-project/ui-backend/internal/utils"
+	"github.com/openmcp-project/ui-backend/pkg/k8s"
+)
+
+// debugInfo describes the running instance. It is served on the admin address, but must not contain secrets either.
+type debugInfo struct {
+	Version            string                    `json:"version"`
+	GoVersion          string                    `json:"goVersion"`
+	CrateServer        string                    `json:"crateServer,omitempty"`
+	KubeconfigLoadedAt *time.Time                `json:"kubeconfigLoadedAt,omitempty"`
+	KubeconfigError    string                    `json:"kubeconfigError,omitempty"`
+	Caches             map[string]k8s.CacheStats `json:"caches"`
+}
+
+func debugInfoHandler(version string, caches map[string]k8s.CacheAdmin) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		info := debugInfo{
+			Version:   version,
+			GoVersion: runtime.Version(),
+			Caches:    cacheStats(caches),
+		}
+		if config, ok := utils.GetCrateKubeconfig(); ok {
+			info.CrateServer = redactServerURL(config.Clusters[0].Cluster.Server)
+		}
+		loadedAt, err := utils.CrateKubeconfigStatus()
+		if !loadedAt.IsZero() {
+			info.KubeconfigLoadedAt = &loadedAt
+		}
+		if err != nil {
+			info.KubeconfigError = err.Error()
+		}
+		writeAdminJSON(w, info)
+	}
+}
+
+// redactServerURL removes the user info and query of the server URL, as they might contain credentials.
+func redactServerURL(server string) string {
+	u, err := url.Parse(server)
+	if err != nil {
+		return "(invalid URL)"
+	}
+	u.User = nil
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
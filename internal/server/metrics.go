@@ -0,0 +1,61 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ui_backend_requests_total",
+		Help: "Requests handled by the proxy.",
+	}, []string{"handler", "cluster", "code", "jq"})
+	// requestDuration doesn't include streamed responses, as watches and followed logs last until the client disconnects
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ui_backend_request_duration_seconds",
+		Help:    "Duration of requests handled by the proxy, without streamed responses.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"handler", "cluster", "code", "jq"})
+	jqDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "ui_backend_jq_duration_seconds",
+		Help:    "Duration of jq executions.",
+		Buckets: prometheus.DefBuckets,
+	})
+)
+
+// requestMetrics collects the labels of a request, they have to be read before the handler removes the headers.
+type requestMetrics struct {
+	start   time.Time
+	handler string
+	cluster string
+	jq      string
+}
+
+func newRequestMetrics(handlerName string, req *http.Request) requestMetrics {
+	cluster := "mcp"
+	if useCrate, _ := strconv.ParseBool(req.Header.Get(useCrateClusterHeader)); useCrate {
+		cluster = "crate"
+	}
+	return requestMetrics{
+		start:   time.Now(),
+		handler: handlerName,
+		cluster: cluster,
+		jq:      strconv.FormatBool(req.Header.Get(jqHeader) != ""),
+	}
+}
+
+// observe records the request with the status code of the response.
+func (m requestMetrics) observe(statusCode int, streamed bool) {
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+	code := strconv.Itoa(statusCode)
+	requestsTotal.WithLabelValues(m.handler, m.cluster, code, m.jq).Inc()
+	if !streamed {
+		requestDuration.WithLabelValues(m.handler, m.cluster, code, m.jq).Observe(time.Since(m.start).Seconds())
+	}
+}
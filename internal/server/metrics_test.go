@@ -0,0 +1,75 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRequestMetrics(t *testing.T) {
+	handler := defaultHandler(&shared{}, "metrics-test", func(_ *shared, req *http.Request, res *response) (*response, *HttpError) {
+		if req.URL.Query().Get("fail") != "" {
+			return nil, NewBadRequestError("failed")
+		}
+		if req.URL.Query().Get("stream") != "" {
+			res.stream = func(w http.ResponseWriter, req *http.Request) error {
+				_, err := w.Write([]byte("{}"))
+				return err
+			}
+			return res, nil
+		}
+		res.body = []byte("{}")
+		return res, nil
+	})
+
+	tests := []struct {
+		name     string
+		target   string
+		headers  map[string]string
+		expected []string
+	}{
+		{
+			name:     "crate",
+			target:   "/",
+			headers:  map[string]string{useCrateClusterHeader: "true"},
+			expected: []string{"metrics-test", "crate", "200", "false"},
+		},
+		{
+			name:     "mcp with jq",
+			target:   "/",
+			headers:  map[string]string{jqHeader: ".items"},
+			expected: []string{"metrics-test", "mcp", "200", "true"},
+		},
+		{
+			name:     "error",
+			target:   "/?fail=true",
+			headers:  map[string]string{useCrateClusterHeader: "true", jqHeader: ".items"},
+			expected: []string{"metrics-test", "crate", "400", "true"},
+		},
+		{
+			name:     "streamed",
+			target:   "/?stream=true",
+			headers:  map[string]string{useCrateClusterHeader: "false"},
+			expected: []string{"metrics-test", "mcp", "200", "false"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			counter := requestsTotal.WithLabelValues(test.expected...)
+			before := testutil.ToFloat64(counter)
+
+			req := httptest.NewRequest(http.MethodGet, test.target, nil)
+			for k, v := range test.headers {
+				req.Header.Set(k, v)
+			}
+			handler(httptest.NewRecorder(), req)
+
+			if actual := testutil.ToFloat64(counter) - before; actual != 1 {
+				t.Errorf("expected the request to be counted once with the labels %q but got %v", test.expected, actual)
+			}
+		})
+	}
+}
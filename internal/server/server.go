@@ -6,18 +6,31 @@ import (
 	"github.com/openmcp-project/ui-backend/pkg/k8s"
 )
 
-func NewMiddleware(theCrateKube k8s.Kube, theDownstreamKube k8s.Kube, jqConfig JQConfig) *http.ServeMux {
+func NewMiddleware(theCrateKube k8s.Kube, theDownstreamKube k8s.Kube, config Config) *http.ServeMux {
 	shared := &shared{
-		crateKube:      theCrateKube,
-		downstreamKube: theDownstreamKube,
-		jqConfig:       jqConfig,
+		crateKube:         theCrateKube,
+		downstreamKube:    theDownstreamKube,
+		jqConfig:          config.JQ,
+		jsonPathConfig:    config.JsonPath,
+		categoryConfig:    config.Category,
+		compressionConfig: config.Compression,
+		corsConfig:        config.CORS,
+		informers:         config.Informers,
+		health:            config.Health,
 	}
 
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("/managed", defaultHandler(shared, managedHandler))
-	mux.HandleFunc("/c/", defaultHandler(shared, categoryHandler))
-	mux.HandleFunc("/", defaultHandler(shared, mainHandler))
+	if config.Health != nil {
+		mux.HandleFunc("/healthz", config.Health.liveHandler)
+		mux.HandleFunc("/readyz", config.Health.readyHandler)
+	}
+
+	mux.HandleFunc("/managed", defaultHandler(shared, "managed", managedHandler))
+	mux.HandleFunc("/c/", defaultHandler(shared, "category", categoryHandler))
+	mux.HandleFunc("/logs", defaultHandler(shared, "logs", logsHandler))
+	mux.HandleFunc(upgradePathPrefix+"/", upgradeHandler(shared))
+	mux.HandleFunc("/", defaultHandler(shared, "main", mainHandler))
 
 	return mux
 }
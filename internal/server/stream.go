@@ -0,0 +1,225 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	eventStreamContentType = "text/event-stream"
+	lastEventIdHeader      = "Last-Event-ID"
+)
+
+// isWatchRequest reports whether the client asked the api server for a watch stream (?watch=true).
+func isWatchRequest(data ExtractedRequestData) bool {
+	watch, err := strconv.ParseBool(data.Query.Get("watch"))
+	return err == nil && watch
+}
+
+// isFollowLogRequest reports whether the client asked for the logs of a pod in follow mode (pods/{name}/log?follow=true).
+func isFollowLogRequest(data ExtractedRequestData) bool {
+	follow, err := strconv.ParseBool(data.Query.Get("follow"))
+	return err == nil && follow && strings.HasSuffix(data.Path, "/log")
+}
+
+// wantsEventStream reports whether the client wants the watch events as Server-Sent Events.
+func wantsEventStream(req *http.Request) bool {
+	for _, accept := range req.Header.Values("Accept") {
+		if strings.Contains(accept, eventStreamContentType) {
+			return true
+		}
+	}
+	return false
+}
+
+// prepareWatchRequest adjusts the request data of a watch request before it is sent to the api server.
+// When the client wants Server-Sent Events, the api server still has to answer with plain JSON watch events.
+// A reconnecting EventSource sends the id of the last received event, which is the resourceVersion to resume from.
+func prepareWatchRequest(req *http.Request, data *ExtractedRequestData) {
+	if !wantsEventStream(req) {
+		return
+	}
+
+	data.Headers["Accept"] = []string{"application/json"}
+	if lastEventId := req.Header.Get(lastEventIdHeader); lastEventId != "" && data.Query.Get("resourceVersion") == "" {
+		data.Query.Set("resourceVersion", lastEventId)
+	}
+	http.Header(data.Headers).Del(lastEventIdHeader)
+}
+
+// watchEvent is the part of a kubernetes watch event needed to build a Server-Sent Event.
+type watchEvent struct {
+	Type   string `json:"type"`
+	Object struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+	} `json:"object"`
+}
+
+// eventFilter transforms a single watch event. An empty result drops the event.
+type eventFilter func(ctx context.Context, event []byte) (string, error)
+
+// buildStreamResponse turns the response into a streaming response which forwards the upstream body as it arrives.
+// The response takes ownership of the upstream body and closes it once the stream ends.
+func (r *response) buildStreamResponse(k8sResp *http.Response, sse bool, filter eventFilter) {
+	for k, v := range k8sResp.Header {
+		if !InSlice(prohibitedResponseHeaders, k) {
+			for _, vv := range v {
+				r.AddHeader(k, vv)
+			}
+		}
+	}
+	r.statusCode = k8sResp.StatusCode
+	r.contentType = k8sResp.Header.Get("Content-Type")
+	if sse {
+		r.contentType = eventStreamContentType
+		r.AddHeader("Cache-Control", "no-cache")
+		// prevent reverse proxies like nginx from buffering the stream
+		r.AddHeader("X-Accel-Buffering", "no")
+	} else if filter != nil {
+		r.contentType = "application/json"
+	}
+
+	r.stream = func(w http.ResponseWriter, req *http.Request) error {
+		defer func() {
+			if errC := k8sResp.Body.Close(); errC != nil {
+				slog.Error("failed to close api server response body", "err", errC)
+			}
+		}()
+
+		if !sse && filter == nil {
+			return copyFlushing(w, k8sResp.Body)
+		}
+		return writeEvents(req.Context(), w, k8sResp.Body, sse, filter)
+	}
+}
+
+// copyFlushing copies the upstream body to the client and flushes after every read, so nothing gets stuck in buffers.
+func copyFlushing(w http.ResponseWriter, body io.Reader) error {
+	rc := http.NewResponseController(w)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, errWrite := w.Write(buf[:n]); errWrite != nil {
+				return errWrite
+			}
+			if errFlush := rc.Flush(); errFlush != nil {
+				return errFlush
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// writeEvents decodes the upstream watch events one by one and writes them either as newline delimited JSON or as Server-Sent Events.
+func writeEvents(ctx context.Context, w http.ResponseWriter, body io.Reader, sse bool, filter eventFilter) error {
+	rc := http.NewResponseController(w)
+	decoder := json.NewDecoder(body)
+	for {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			if errors.Is(err, io.EOF) || ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to decode watch event: %w", err)
+		}
+
+		output := string(raw)
+		if filter != nil {
+			filtered, err := filter(ctx, raw)
+			if err != nil {
+				return err
+			}
+			output = filtered
+		}
+		if output == "" {
+			continue
+		}
+
+		var err error
+		if sse {
+			err = writeServerSentEvent(w, raw, output)
+		} else {
+			_, err = io.WriteString(w, output+"\n")
+		}
+		if err != nil {
+			return err
+		}
+		if err := rc.Flush(); err != nil {
+			return err
+		}
+	}
+}
+
+// writeServerSentEvent writes a single watch event as Server-Sent Event. The event name is the watch event type
+// (ADDED, MODIFIED, DELETED, BOOKMARK or ERROR) and the id is the resourceVersion of the object.
+func writeServerSentEvent(w io.Writer, raw json.RawMessage, data string) error {
+	var event watchEvent
+	if err := json.Unmarshal(raw, &event); err != nil {
+		return fmt.Errorf("failed to decode watch event: %w", err)
+	}
+
+	var sb strings.Builder
+	if event.Type != "" {
+		sb.WriteString("event: " + event.Type + "\n")
+	}
+	if event.Object.Metadata.ResourceVersion != "" {
+		sb.WriteString("id: " + event.Object.Metadata.ResourceVersion + "\n")
+	}
+	for _, line := range strings.Split(data, "\n") {
+		sb.WriteString("data: " + line + "\n")
+	}
+	sb.WriteString("\n")
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// watchEventFilter returns the filter applied to every watch event, or nil if the client didn't ask for filtering.
+func (s *shared) watchEventFilter(data ExtractedRequestData) (eventFilter, error) {
+	if data.JQ != "" {
+		if len(data.JQ) > s.jqConfig.MaxExpressionLength {
+			return nil, errors.New("jq expression exceeds maximum allowed length")
+		}
+		return jqEventFilter(data.JQ, s.jqConfig), nil
+	}
+	if data.JsonPath != "" {
+		if len(data.JsonPath) > s.jsonPathConfig.MaxExpressionLength {
+			return nil, errors.New("jsonpath expression exceeds maximum allowed length")
+		}
+		return jsonPathEventFilter(data.JsonPath, s.jsonPathConfig), nil
+	}
+	return nil, nil
+}
+
+func jqEventFilter(expression string, jqConfig JQConfig) eventFilter {
+	return func(ctx context.Context, event []byte) (string, error) {
+		ctx, cancel := context.WithTimeout(ctx, jqConfig.ExecutionTimeout)
+		defer cancel()
+
+		return ParseJQ(ctx, event, expression, jqConfig.MaxResults)
+	}
+}
+
+func jsonPathEventFilter(template string, jsonPathConfig JsonPathConfig) eventFilter {
+	return func(ctx context.Context, event []byte) (string, error) {
+		ctx, cancel := context.WithTimeout(ctx, jsonPathConfig.ExecutionTimeout)
+		defer cancel()
+
+		return ParseJsonPath(ctx, event, template, jsonPathConfig.MaxOutputSize)
+	}
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testWatchBody = `{"type":"ADDED","object":{"metadata":{"name":"a","resourceVersion":"1"}}}
+{"type":"MODIFIED","object":{"metadata":{"name":"a","resourceVersion":"2"}}}
+{"type":"DELETED","object":{"metadata":{"name":"b","resourceVersion":"3"}}}
+`
+
+func TestWatchStream(t *testing.T) {
+	jqConfig := JQConfig{MaxExpressionLength: 500, ExecutionTimeout: time.Second, MaxResults: 100}
+
+	tests := []struct {
+		name     string
+		sse      bool
+		filter   eventFilter
+		expected string
+	}{
+		{
+			name:     "passed through",
+			expected: testWatchBody,
+		},
+		{
+			name: "server-sent events",
+			sse:  true,
+			expected: "event: ADDED\nid: 1\ndata: " + `{"type":"ADDED","object":{"metadata":{"name":"a","resourceVersion":"1"}}}` + "\n\n" +
+				"event: MODIFIED\nid: 2\ndata: " + `{"type":"MODIFIED","object":{"metadata":{"name":"a","resourceVersion":"2"}}}` + "\n\n" +
+				"event: DELETED\nid: 3\ndata: " + `{"type":"DELETED","object":{"metadata":{"name":"b","resourceVersion":"3"}}}` + "\n\n",
+		},
+		{
+			name:     "jq per event drops empty results",
+			filter:   jqEventFilter(`select(.type != "DELETED") | .object.metadata.name`, jqConfig),
+			expected: "\"a\"\n\"a\"\n",
+		},
+		{
+			name:     "jq per server-sent event",
+			sse:      true,
+			filter:   jqEventFilter(`.object.metadata.name, .type`, jqConfig),
+			expected: "event: ADDED\nid: 1\ndata: \"a\"\ndata: \"ADDED\"\n\nevent: MODIFIED\nid: 2\ndata: \"a\"\ndata: \"MODIFIED\"\n\nevent: DELETED\nid: 3\ndata: \"b\"\ndata: \"DELETED\"\n\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			upstream := &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{"application/json"}},
+				Body:       io.NopCloser(strings.NewReader(testWatchBody)),
+			}
+			res := &response{}
+			res.buildStreamResponse(upstream, test.sse, test.filter)
+
+			rec := httptest.NewRecorder()
+			if err := res.stream(rec, httptest.NewRequest(http.MethodGet, "/api/v1/pods?watch=true", nil)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if actual := rec.Body.String(); actual != test.expected {
+				t.Errorf("expected\n%s\nbut got\n%s", test.expected, actual)
+			}
+			if test.sse && res.contentType != eventStreamContentType {
+				t.Errorf("expected content type %q but got %q", eventStreamContentType, res.contentType)
+			}
+		})
+	}
+}
+
+func TestPrepareWatchRequest(t *testing.T) {
+	tests := []struct {
+		name                    string
+		accept                  string
+		lastEventId             string
+		resourceVersion         string
+		expectedResourceVersion string
+		expectedAccept          string
+	}{
+		{
+			name:                    "resumes from the last event",
+			accept:                  eventStreamContentType,
+			lastEventId:             "42",
+			expectedResourceVersion: "42",
+			expectedAccept:          "application/json",
+		},
+		{
+			name:                    "explicit resourceVersion wins",
+			accept:                  eventStreamContentType,
+			lastEventId:             "42",
+			resourceVersion:         "7",
+			expectedResourceVersion: "7",
+			expectedAccept:          "application/json",
+		},
+		{
+			name:           "plain watch is unchanged",
+			accept:         "application/json;as=Table",
+			lastEventId:    "42",
+			expectedAccept: "application/json;as=Table",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/pods?watch=true", nil)
+			req.Header.Set("Accept", test.accept)
+			req.Header.Set(lastEventIdHeader, test.lastEventId)
+			// the headers are forwarded from the request, so their keys are canonical
+			data := ExtractedRequestData{
+				Query:   url.Values{"watch": []string{"true"}},
+				Headers: req.Header.Clone(),
+			}
+			if test.resourceVersion != "" {
+				data.Query.Set("resourceVersion", test.resourceVersion)
+			}
+
+			prepareWatchRequest(req, &data)
+			if actual := data.Query.Get("resourceVersion"); actual != test.expectedResourceVersion {
+				t.Errorf("expected resourceVersion %q but got %q", test.expectedResourceVersion, actual)
+			}
+			if actual := data.Headers["Accept"][0]; actual != test.expectedAccept {
+				t.Errorf("expected Accept %q but got %q", test.expectedAccept, actual)
+			}
+			if actual := http.Header(data.Headers).Get(lastEventIdHeader); wantsEventStream(req) && actual != "" {
+				t.Errorf("expected %s not to be forwarded but got %q", lastEventIdHeader, actual)
+			}
+		})
+	}
+}
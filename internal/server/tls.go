@@ -0,0 +1,21 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+)
+
+// probePaths are served without a client certificate, as the kubelet can't send one.
+var probePaths = []string{"/healthz", "/readyz"}
+
+// RequireClientCertificate rejects requests over connections without a client certificate. The certificate itself is
+// verified during the handshake, see utils.WatchedCertificate.
+func RequireClientCertificate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if (req.TLS == nil || len(req.TLS.PeerCertificates) == 0) && !slices.Contains(probePaths, req.URL.Path) {
+			writeError(w, NewHttpError(http.StatusUnauthorized, "a client certificate is required"))
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
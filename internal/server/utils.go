@@ -4,12 +4,15 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/itchyny/gojq"
+	"k8s.io/client-go/util/jsonpath"
 )
 
 func InSlice[T comparable](slice []T, el T) bool {
@@ -53,6 +56,11 @@ func CopyResponse(resp *response, upstream *http.Response, customBody []byte, fi
 }
 
 func ParseJQ(ctx context.Context, inputJson []byte, inputJQ string, maxResults int) (string, error) {
+	start := time.Now()
+	defer func() {
+		jqDuration.Observe(time.Since(start).Seconds())
+	}()
+
 	query, err := gojq.Parse(inputJQ)
 	if err != nil {
 		return "", fmt.Errorf("invalid jq expression")
@@ -85,6 +93,70 @@ func ParseJQ(ctx context.Context, inputJson []byte, inputJQ string, maxResults i
 	return strings.Join(result[:], "\n"), nil
 }
 
+var errJsonPathOutputTooLarge = errors.New("jsonpath output exceeds maximum allowed size")
+
+// ParseJsonPath evaluates a kubectl flavoured jsonpath template (e.g. `{range .items[*]}{.metadata.name}{"\n"}{end}`)
+// against the given JSON document. Like kubectl, a template without braces is treated as a single expression.
+// The evaluation is aborted once the context is done or the output grows beyond maxOutputSize bytes.
+func ParseJsonPath(ctx context.Context, inputJson []byte, template string, maxOutputSize int) (string, error) {
+	jp := jsonpath.New("").AllowMissingKeys(true)
+	if err := jp.Parse(relaxedJsonPathTemplate(template)); err != nil {
+		return "", fmt.Errorf("invalid jsonpath expression")
+	}
+
+	var jsonData interface{}
+	err := json.Unmarshal(inputJson, &jsonData)
+	if err != nil {
+		return "", fmt.Errorf("invalid JSON input")
+	}
+
+	// the jsonpath library does not support cancellation, so the evaluation runs in its own goroutine
+	out := &limitedBuffer{limit: maxOutputSize}
+	done := make(chan error, 1)
+	go func() {
+		done <- jp.Execute(out, jsonData)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return "", fmt.Errorf("jsonpath execution failed: %w", ctx.Err())
+	case err := <-done:
+		if errors.Is(err, errJsonPathOutputTooLarge) {
+			return "", err
+		}
+		if err != nil {
+			return "", fmt.Errorf("jsonpath execution failed: %w", err)
+		}
+	}
+
+	return out.String(), nil
+}
+
+// relaxedJsonPathTemplate mirrors kubectl, which accepts expressions like `.items[*].metadata.name` without braces.
+func relaxedJsonPathTemplate(template string) string {
+	template = strings.TrimSpace(template)
+	if strings.Contains(template, "{") {
+		return template
+	}
+	if !strings.HasPrefix(template, ".") {
+		template = "." + template
+	}
+	return "{" + template + "}"
+}
+
+// limitedBuffer is a bytes.Buffer which refuses writes beyond its limit.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.limit {
+		return 0, errJsonPathOutputTooLarge
+	}
+	return b.Buffer.Write(p)
+}
+
 // parseAuthorizationHeaderWithDoubleTokens parses an authorization header that may contain two tokens separated by a comma.
 // It returns the first token and the second token (if present). If the second token is absent, it returns an empty string for it.
 // If the header is empty or contains more than two tokens, it returns an error.
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"testing"
 )
 
@@ -39,3 +40,42 @@ func TestParseAuthorizationHeaderWithDoubleTokens(t *testing.T) {
 		})
 	}
 }
+
+func TestParseJsonPath(t *testing.T) {
+	input := []byte(`{"items":[{"metadata":{"name":"a","namespace":"x"}},{"metadata":{"name":"b","namespace":"y"}}]}`)
+
+	tests := []struct {
+		name          string
+		template      string
+		maxOutputSize int
+		expected      string
+		expectErr     bool
+	}{
+		{"braces", "{.items[*].metadata.name}", 100, "a b", false},
+		{"relaxed", ".items[0].metadata.name", 100, "a", false},
+		{"relaxed without dot", "items[1].metadata.namespace", 100, "y", false},
+		{"range", `{range .items[*]}{.metadata.namespace}/{.metadata.name}{"\n"}{end}`, 100, "x/a\ny/b\n", false},
+		{"missing key", "{.items[*].spec.foo}", 100, "", false},
+		{"invalid", "{.items[*", 100, "", true},
+		{"output too large", "{.items[*].metadata.name}", 2, "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, err := ParseJsonPath(context.Background(), input, test.template, test.maxOutputSize)
+
+			if test.expectErr {
+				if err == nil {
+					t.Errorf("expected an error but got none")
+				}
+			} else {
+				if err != nil {
+					t.Errorf("expected no error but got: %v", err)
+				}
+				if output != test.expected {
+					t.Errorf("expected output to be %q but got %q", test.expected, output)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package utils
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// WatchedCertificate is the serving certificate of the server and optionally the CA for client certificates, which
+// are reloaded when their files change.
+type WatchedCertificate struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	mu          sync.RWMutex
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+}
+
+// NewWatchedCertificate reads the certificate, its key and, if the file is set, the client CA.
+func NewWatchedCertificate(certFile, keyFile, clientCAFile string) (*WatchedCertificate, error) {
+	c := &WatchedCertificate{certFile: certFile, keyFile: keyFile, clientCAFile: clientCAFile}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// TLSConfig returns the server configuration using the current certificate. If a client CA is configured, client
+// certificates are requested and verified if they are sent. Whether they are required is up to the handler, so that
+// probes without a client certificate can still be served.
+func (c *WatchedCertificate) TLSConfig() *tls.Config {
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: c.getCertificate,
+	}
+	if c.clientCAFile != "" {
+		// the CA pool is checked on every handshake instead of setting ClientCAs, so it can be reloaded. Unlike
+		// VerifyPeerCertificate, VerifyConnection is also called for resumed sessions, so these are checked against
+		// the current CA as well.
+		config.ClientAuth = tls.RequestClientCert
+		config.VerifyConnection = c.verifyClientCertificate
+	}
+	return config
+}
+
+// Watch reloads the files when they change, until the context is canceled. The directories are watched instead of
+// the files, because mounted secrets are replaced by swapping a symlink, which doesn't send events for the files.
+// If a file can't be read, e.g. because the certificate was written before its key, the current one is kept.
+func (c *WatchedCertificate) Watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to watch certificate: %w", err)
+	}
+	defer func() {
+		if err := watcher.Close(); err != nil {
+			slog.Error("failed to close certificate watcher", "err", err)
+		}
+	}()
+
+	var dirs []string
+	for _, file := range []string{c.certFile, c.keyFile, c.clientCAFile} {
+		if dir := filepath.Dir(file); file != "" && !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	for _, dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			return fmt.Errorf("failed to watch certificate: %w", err)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			slog.Error("error while watching certificate", "err", err)
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Has(fsnotify.Chmod) {
+				continue
+			}
+			if err := c.load(); err != nil {
+				slog.Warn("failed to reload certificate, keeping the current one", "err", err)
+				continue
+			}
+			slog.Info("reloaded certificate", "file", c.certFile)
+		}
+	}
+}
+
+func (c *WatchedCertificate) load() error {
+	certificate, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if c.clientCAFile != "" {
+		pem, err := os.ReadFile(c.clientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA: %w", err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("client CA %s contains no certificates", c.clientCAFile)
+		}
+	}
+
+	c.mu.Lock()
+	c.certificate = &certificate
+	c.clientCAs = clientCAs
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *WatchedCertificate) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.certificate, nil
+}
+
+// verifyClientCertificate verifies the client certificate against the current client CA. Connections without a
+// client certificate are accepted.
+func (c *WatchedCertificate) verifyClientCertificate(state tls.ConnectionState) error {
+	certs := state.PeerCertificates
+	if len(certs) == 0 {
+		return nil
+	}
+
+	c.mu.RLock()
+	roots := c.clientCAs
+	c.mu.RUnlock()
+	if roots == nil {
+		return errors.New("no client CA configured")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return err
+}
@@ -0,0 +1,193 @@
+package utils
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchedCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	caFile := filepath.Join(dir, "ca.crt")
+
+	ca, caKey := generateCertificate(t, "ca", nil, nil)
+	writeCertificate(t, caFile, ca)
+	serving, servingKey := generateCertificate(t, "first", ca, caKey)
+	writeCertificate(t, certFile, serving)
+	writeKey(t, keyFile, servingKey)
+
+	certificate, err := NewWatchedCertificate(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatalf("failed to load certificate: %v", err)
+	}
+
+	client, _ := generateCertificate(t, "client", ca, caKey)
+	if err := certificate.verifyClientCertificate(tls.ConnectionState{PeerCertificates: []*x509.Certificate{client}}); err != nil {
+		t.Errorf("expected client certificate signed by the CA to be accepted but got %v", err)
+	}
+	other, _ := generateCertificate(t, "other", nil, nil)
+	if err := certificate.verifyClientCertificate(tls.ConnectionState{PeerCertificates: []*x509.Certificate{other}}); err == nil {
+		t.Errorf("expected client certificate of another CA to be rejected")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = certificate.Watch(ctx)
+	}()
+
+	// the events of writes before the watcher started are lost, so the files are written until they are reloaded
+	renewed, renewedKey := generateCertificate(t, "second", ca, caKey)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		writeKey(t, keyFile, renewedKey)
+		writeCertificate(t, certFile, renewed)
+		time.Sleep(10 * time.Millisecond)
+
+		current, _ := certificate.getCertificate(nil)
+		if current.Leaf != nil && current.Leaf.Subject.CommonName == "second" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the renewed certificate to be loaded")
+		}
+	}
+}
+
+func TestWatchedCertificateResumedSession(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	caFile := filepath.Join(dir, "ca.crt")
+
+	ca, caKey := generateCertificate(t, "ca", nil, nil)
+	writeCertificate(t, caFile, ca)
+	serving, servingKey := generateCertificate(t, "server", ca, caKey)
+	writeCertificate(t, certFile, serving)
+	writeKey(t, keyFile, servingKey)
+
+	certificate, err := NewWatchedCertificate(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatalf("failed to load certificate: %v", err)
+	}
+	serverConfig := certificate.TLSConfig()
+	client, clientKey := generateCertificate(t, "client", ca, caKey)
+	clientConfig := &tls.Config{
+		// only the verification of the client is tested
+		InsecureSkipVerify: true,
+		Certificates:       []tls.Certificate{{Certificate: [][]byte{client.Raw}, PrivateKey: clientKey}},
+		ClientSessionCache: tls.NewLRUClientSessionCache(1),
+	}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// handshake returns whether the session was resumed and the error of the server
+	handshake := func() (bool, error) {
+		serverErr := make(chan error, 1)
+		go func() {
+			serverConn, err := listener.Accept()
+			if err != nil {
+				serverErr <- err
+				return
+			}
+			conn := serverConn.(*tls.Conn)
+			defer conn.Close()
+			err = conn.Handshake()
+			if err == nil {
+				// the client receives the session ticket with the first read
+				_, err = conn.Write([]byte("ok"))
+			}
+			serverErr <- err
+		}()
+
+		conn, err := tls.Dial("tcp", listener.Addr().String(), clientConfig)
+		if err != nil {
+			return false, errors.Join(err, <-serverErr)
+		}
+		defer conn.Close()
+		_, _ = conn.Read(make([]byte, 2))
+		return conn.ConnectionState().DidResume, <-serverErr
+	}
+
+	if _, err := handshake(); err != nil {
+		t.Fatalf("expected the client certificate to be accepted but got %v", err)
+	}
+	if resumed, err := handshake(); err != nil || !resumed {
+		t.Fatalf("expected the session to be resumed but got %v and %v", resumed, err)
+	}
+
+	// after the client CA was replaced, resumed sessions of clients of the old CA are rejected as well
+	otherCA, _ := generateCertificate(t, "other", nil, nil)
+	writeCertificate(t, caFile, otherCA)
+	if err := certificate.load(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handshake(); err == nil {
+		t.Errorf("expected the resumed session to be rejected by the new client CA")
+	}
+}
+
+// generateCertificate returns a certificate signed by the parent, or a self-signed CA if the parent is nil.
+func generateCertificate(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func writeCertificate(t *testing.T, path string, cert *x509.Certificate) {
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeKey(t *testing.T, path string, key *ecdsa.PrivateKey) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -2,10 +2,12 @@ package utils
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/openmcp-project/ui-backend/pkg/k8s"
@@ -13,7 +15,11 @@ import (
 
 type watchedKubeconfig struct {
 	kubeconfig k8s.KubeConfig
-	mu         sync.RWMutex
+	// loadedAt is the time the kubeconfig was last read successfully
+	loadedAt time.Time
+	// err is the error of the last attempt to read the kubeconfig, it is reset by a successful reload
+	err error
+	mu  sync.RWMutex
 }
 
 var crateKubeconfig watchedKubeconfig
@@ -34,73 +40,127 @@ func GetCrateKubeconfig() (k8s.KubeConfig, bool) {
 	return config, true
 }
 
+// CrateKubeconfigStatus returns when the crate kubeconfig was last loaded, which is zero if it never was, and the
+// error of the last failed attempt to read it. After a failed reload, the previous kubeconfig is still used.
+func CrateKubeconfigStatus() (time.Time, error) {
+	crateKubeconfig.mu.RLock()
+	defer crateKubeconfig.mu.RUnlock()
+
+	return crateKubeconfig.loadedAt, crateKubeconfig.err
+}
+
+// The kubeconfig is read again with exponential backoff while it can't be read or watched, e.g. until it is mounted.
+var (
+	kubeconfigRetryInitialBackoff = time.Second
+	kubeconfigRetryMaxBackoff     = 30 * time.Second
+)
+
+var errKubeconfigReplaced = errors.New("kubeconfig was removed or replaced")
+
+// StartListeningOnKubeconfig reads the kubeconfig and reloads it when the file is written, until the context is
+// canceled. Failures are logged and recorded instead of exiting, so the readiness check can report them. If the file
+// can't be read or watched, e.g. because it doesn't exist yet or was replaced, both are retried.
 func StartListeningOnKubeconfig(ctx context.Context, path string) {
-	log.Println("Listening on kubeconfig file", path)
+	slog.Info("Listening on kubeconfig file", "path", path)
 
-	// first time reading kubeconfig
-	config, err := readKubeConfig(path)
-	if err != nil {
-		log.Fatalln("failed to read kubeconfig", err)
-		return
-	} else {
-		crateKubeconfig.mu.Lock()
-		crateKubeconfig.kubeconfig = config
-		crateKubeconfig.mu.Unlock()
+	backoff := kubeconfigRetryInitialBackoff
+	for {
+		loaded := loadKubeconfig(path)
+		err := watchKubeconfig(ctx, path)
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, errKubeconfigReplaced) {
+			slog.Info("kubeconfig was replaced", "path", path)
+			backoff = kubeconfigRetryInitialBackoff
+			continue
+		}
+		// a read error is more useful than the watch error that follows from it
+		if loaded {
+			setKubeconfigError(err)
+		} else {
+			slog.Debug("kubeconfig isn't watched", "err", err)
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, kubeconfigRetryMaxBackoff)
 	}
+}
 
+// watchKubeconfig reloads the kubeconfig when the file is written. It returns when the context is canceled, or with an
+// error if the file can't be watched (anymore).
+func watchKubeconfig(ctx context.Context, path string) error {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("failed to watch kubeconfig: %w", err)
 	}
 	defer func() {
-		err := watcher.Close()
-		if err != nil {
-			log.Fatal(err)
+		if err := watcher.Close(); err != nil {
+			slog.Error("failed to close kubeconfig watcher", "err", err)
 		}
 	}()
 
-	// starting the loop to check for changes in kubeconfig file
-	go fileLoop(ctx, watcher, path)
-
-	err = watcher.Add(path)
-	if err != nil {
-		log.Fatal(err)
-		return
+	if err := watcher.Add(path); err != nil {
+		return fmt.Errorf("failed to watch kubeconfig: %w", err)
 	}
 
-	<-ctx.Done()
-}
-
-func fileLoop(ctx context.Context, watcher *fsnotify.Watcher, path string) {
 	for {
 		select {
 		case <-ctx.Done():
-			return
+			return nil
 		case err, ok := <-watcher.Errors:
 			if !ok {
-				return
+				return errors.New("kubeconfig watcher closed")
 			}
-			log.Fatalln("error:", err)
+			slog.Error("error while watching kubeconfig", "err", err)
 		case event, ok := <-watcher.Events:
 			if !ok {
-				return
+				return errors.New("kubeconfig watcher closed")
 			}
 
 			if event.Has(fsnotify.Write) {
-				config, err := readKubeConfig(path)
-				if err != nil {
-					log.Fatalln("failed to read kubeconfig", err)
-					return
-				} else {
-					crateKubeconfig.mu.Lock()
-					crateKubeconfig.kubeconfig = config
-					crateKubeconfig.mu.Unlock()
-				}
+				loadKubeconfig(path)
+			}
+			// a replaced file has to be read and watched again
+			if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+				return errKubeconfigReplaced
 			}
 		}
 	}
 }
 
+// loadKubeconfig reads the kubeconfig and replaces the current one. If it can't be read, the current one is kept.
+// It returns whether the kubeconfig was read.
+func loadKubeconfig(path string) bool {
+	config, err := readKubeConfig(path)
+	if err != nil {
+		setKubeconfigError(fmt.Errorf("failed to read kubeconfig: %w", err))
+		return false
+	}
+
+	crateKubeconfig.mu.Lock()
+	crateKubeconfig.kubeconfig = config
+	crateKubeconfig.loadedAt = time.Now()
+	crateKubeconfig.err = nil
+	crateKubeconfig.mu.Unlock()
+	slog.Info("loaded kubeconfig", "path", path)
+	return true
+}
+
+func setKubeconfigError(err error) {
+	slog.Error("crate kubeconfig unavailable", "err", err)
+
+	crateKubeconfig.mu.Lock()
+	crateKubeconfig.err = err
+	crateKubeconfig.mu.Unlock()
+}
+
 func readKubeConfig(path string) (k8s.KubeConfig, error) {
 	content, err := os.ReadFile(path)
 	if err != nil {
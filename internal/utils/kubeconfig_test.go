@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/openmcp-project/ui-backend/pkg/k8s"
+)
+
+func TestStartListeningOnKubeconfig(t *testing.T) {
+	kubeconfigRetryInitialBackoff, kubeconfigRetryMaxBackoff = 10*time.Millisecond, 50*time.Millisecond
+	crateKubeconfig.mu.Lock()
+	crateKubeconfig.kubeconfig, crateKubeconfig.loadedAt, crateKubeconfig.err = k8s.KubeConfig{}, time.Time{}, nil
+	crateKubeconfig.mu.Unlock()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "kubeconfig")
+	writeKubeconfig := func(server string) {
+		// the file is replaced like a mounted secret is updated
+		tmp := filepath.Join(dir, "kubeconfig.tmp")
+		content := "clusters:\n- cluster:\n    server: " + server + "\nusers:\n- user:\n    token: token\n"
+		if err := os.WriteFile(tmp, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitForServer := func(server string) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if config, ok := GetCrateKubeconfig(); ok && config.Clusters[0].Cluster.Server == server {
+				return
+			}
+		}
+		t.Fatalf("expected the kubeconfig of %s to be loaded", server)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go StartListeningOnKubeconfig(ctx, path)
+
+	// the file appears later
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if _, err := CrateKubeconfigStatus(); err != nil {
+			break
+		}
+	}
+	if _, err := CrateKubeconfigStatus(); err == nil {
+		t.Errorf("expected an error while the kubeconfig doesn't exist")
+	}
+	if _, ok := GetCrateKubeconfig(); ok {
+		t.Errorf("expected no kubeconfig while the file doesn't exist")
+	}
+	writeKubeconfig("https://a")
+	waitForServer("https://a")
+	if loadedAt, err := CrateKubeconfigStatus(); err != nil || loadedAt.IsZero() {
+		t.Errorf("expected the kubeconfig to be loaded without error but got %v", err)
+	}
+
+	// the replaced file is watched again
+	writeKubeconfig("https://b")
+	waitForServer("https://b")
+	writeKubeconfig("https://c")
+	waitForServer("https://c")
+}
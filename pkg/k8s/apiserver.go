@@ -1,32 +1,32 @@
 package k8s
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"hash/fnv"
 	"io"
 	"log/slog"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
 	"time"
 
-	"github.com/patrickmn/go-cache"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
 	"k8s.io/api/apidiscovery/v2beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-func RequestApiServer(kube Kube, request Request, config KubeConfig, result interface{}) error {
+func RequestApiServer(ctx context.Context, kube Kube, request Request, config KubeConfig, result interface{}) error {
 	if request.Headers == nil {
 		request.Headers = make(map[string][]string)
 	}
 	request.Headers["Content-Type"] = []string{"application/json"}
 
-	res, err := kube.RequestApiServerRaw(request, config)
+	res, err := kube.RequestApiServerRaw(ctx, request, config)
 	if err != nil {
 		return err
 	}
@@ -37,6 +37,10 @@ func RequestApiServer(kube Kube, request Request, config KubeConfig, result inte
 		return err
 	}
 
+	if res.StatusCode >= 400 {
+		return NewStatusError(res.StatusCode, body)
+	}
+
 	if err := json.Unmarshal(body, result); err != nil {
 		return fmt.Errorf("failed to decode json response: %v", err)
 	}
@@ -44,6 +48,25 @@ func RequestApiServer(kube Kube, request Request, config KubeConfig, result inte
 	return nil
 }
 
+// StatusError is returned if the api server responds with an error status code.
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("api server responded with status %d: %s", e.Code, e.Message)
+}
+
+// NewStatusError creates a StatusError from an api server response body, using the message of the Status object if there is one.
+func NewStatusError(code int, body []byte) *StatusError {
+	status := metav1.Status{}
+	if err := json.Unmarshal(body, &status); err == nil && status.Message != "" {
+		return &StatusError{Code: code, Message: status.Message}
+	}
+	return &StatusError{Code: code, Message: http.StatusText(code)}
+}
+
 type Request struct {
 	Method  string
 	Path    string
@@ -52,17 +75,31 @@ type Request struct {
 	Body    io.Reader
 }
 
+// hasBody returns whether the request has a body. Requests received by a server have http.NoBody instead of nil.
+func (r Request) hasBody() bool {
+	return r.Body != nil && r.Body != http.NoBody
+}
+
+// Kube sends requests to api servers. Canceling the context aborts the request, for streamed responses also the reading
+// of the body.
 type Kube interface {
-	RequestApiServerRaw(request Request, config KubeConfig) (*http.Response, error)
-	RequestApiGroupsByCategory(config KubeConfig, category string) ([]v2beta1.APIGroupDiscovery, error)
+	RequestApiServerRaw(ctx context.Context, request Request, config KubeConfig) (*http.Response, error)
+	RequestApiGroupsByCategory(ctx context.Context, config KubeConfig, category string) ([]v2beta1.APIGroupDiscovery, error)
 }
 
 var _ Kube = HttpKube{}
 
-type HttpKube struct{}
+// ErrUpstreamTimeout is returned if an api server didn't send the response headers within HttpKube.Timeout.
+var ErrUpstreamTimeout = errors.New("api server didn't respond in time")
 
-// RequestApiServerRaw It is expected that the config of type Kubeconfig is valid, meaning the arrays .clusters and .users are not empty
-func (HttpKube) RequestApiServerRaw(request Request, config KubeConfig) (*http.Response, error) {
+type HttpKube struct {
+	// Timeout limits the time until the response headers are received, zero means no limit. The body isn't covered, so
+	// watches and followed logs aren't ended by it.
+	Timeout time.Duration
+}
+
+// NewTLSConfig builds the TLS client configuration for the first cluster and user of the kubeconfig.
+func NewTLSConfig(config KubeConfig) (*tls.Config, error) {
 	tlsConfig := tls.Config{}
 	if len(config.Clusters) == 0 || len(config.Users) == 0 {
 		return nil, fmt.Errorf("invalid kubeconfig: empty clusters or users")
@@ -103,6 +140,21 @@ func (HttpKube) RequestApiServerRaw(request Request, config KubeConfig) (*http.R
 		tlsConfig.Certificates = []tls.Certificate{clientCert}
 	}
 
+	return &tlsConfig, nil
+}
+
+// RequestApiServerRaw It is expected that the config of type Kubeconfig is valid, meaning the arrays .clusters and .users are not empty
+func (h HttpKube) RequestApiServerRaw(ctx context.Context, request Request, config KubeConfig) (*http.Response, error) {
+	if len(config.Clusters) == 0 || len(config.Users) == 0 {
+		return nil, fmt.Errorf("invalid kubeconfig: empty clusters or users")
+	}
+	transport, err := defaultTransportPool.get(config)
+	if err != nil {
+		return nil, err
+	}
+	cluster := config.Clusters[0]
+	user := config.Users[0]
+
 	requestUrlStr, err := url.JoinPath(cluster.Cluster.Server, request.Path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to join url path: %v", err)
@@ -113,14 +165,18 @@ func (HttpKube) RequestApiServerRaw(request Request, config KubeConfig) (*http.R
 		return nil, fmt.Errorf("failed to parse url: %v", err)
 	}
 
+	query := requestUrl.Query()
 	for k, v := range request.Query {
 		for _, vv := range v {
-			requestUrl.Query().Add(k, vv)
+			query.Add(k, vv)
 		}
 	}
+	requestUrl.RawQuery = query.Encode()
 
-	req, err := http.NewRequest(request.Method, requestUrl.String(), request.Body)
+	ctx, cancel := context.WithCancelCause(ctx)
+	req, err := http.NewRequestWithContext(ctx, request.Method, requestUrl.String(), request.Body)
 	if err != nil {
+		cancel(nil)
 		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
 
@@ -135,148 +191,47 @@ func (HttpKube) RequestApiServerRaw(request Request, config KubeConfig) (*http.R
 	if user.User.Token != "" {
 		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", user.User.Token))
 	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
 
 	client := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tlsConfig,
-		},
+		Transport: transport,
 	}
 
 	slog.Debug("requesting api server", "method", request.Method, "host", config.Clusters[0].Cluster.Server, "path", request.Path)
-	res, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to request api server: %v", err)
+	// the timeout is stopped once the headers arrived, the context is canceled when the body is closed
+	if h.Timeout > 0 {
+		timer := time.AfterFunc(h.Timeout, func() { cancel(ErrUpstreamTimeout) })
+		defer timer.Stop()
 	}
 
-	return res, nil
-}
-
-func (h HttpKube) RequestApiGroupsByCategory(config KubeConfig, category string) ([]v2beta1.APIGroupDiscovery, error) {
-	acceptHeader := "application/json;g=apidiscovery.k8s.io;v=v2;as=APIGroupDiscoveryList,application/json;g=apidiscovery.k8s.io;v=v2beta1;as=APIGroupDiscoveryList,application/json"
-	req := Request{
-		Method: "GET",
-		Path:   "/api",
-		Headers: map[string][]string{
-			"Accept": {acceptHeader},
-		},
-	}
-
-	var apiGroups v2beta1.APIGroupDiscoveryList
-	if err := RequestApiServer(h, req, config, &apiGroups); err != nil {
-		return nil, err
-	}
-
-	req = Request{
-		Method: "GET",
-		Path:   "/apis",
-		Headers: map[string][]string{
-			"Accept": {acceptHeader},
-		},
-	}
-
-	var apisGroups v2beta1.APIGroupDiscoveryList
-	if err := RequestApiServer(h, req, config, &apiGroups); err != nil {
-		return nil, err
-	}
-
-	apiGroupItems := append(apiGroups.Items, apisGroups.Items...)
-
-	var filteredItems []v2beta1.APIGroupDiscovery
-	for _, item := range apiGroupItems {
-		filteredVersions := []v2beta1.APIVersionDiscovery{}
-		for _, version := range item.Versions {
-			filteredVersion := version
-			filteredResources := []v2beta1.APIResourceDiscovery{}
-			for _, resource := range version.Resources {
-				if resource.Categories != nil {
-					for _, cat := range resource.Categories {
-						if cat == category {
-							filteredResources = append(filteredResources, resource)
-						}
-					}
-				}
-			}
-
-			if len(filteredResources) > 0 {
-				filteredVersion.Resources = filteredResources
-				filteredVersions = append(filteredVersions, filteredVersion)
-			}
-		}
-
-		if len(filteredVersions) > 0 {
-			item.Versions = filteredVersions
-			filteredItems = append(filteredItems, item)
-		}
-	}
-
-	return filteredItems, nil
-}
-
-var _ Kube = cachingKube{}
-
-type cachingKube struct {
-	downstream Kube
-	cache      *cache.Cache
-}
-
-func NewCachingKube(downstream Kube, defaultExpiration, cleanupInterval time.Duration) Kube {
-	kube := cachingKube{
-		downstream: downstream,
-		cache:      cache.New(defaultExpiration, cleanupInterval),
-	}
-	return &kube
-}
-
-func (c cachingKube) RequestApiServerRaw(request Request, config KubeConfig) (*http.Response, error) {
-	// this key is a unique identifier for the request - however, it is not guaranteed to be unique
-	key := fmt.Sprintf("%s %s %s %s %s %v", request.Method, request.Path, request.Body, request.Headers, request.Query, config)
-
-	h := fnv.New32()
-	_, err := h.Write([]byte(key))
+	start := time.Now()
+	res, err := client.Do(req)
 	if err != nil {
-		return nil, err
-	}
-	hashedKey := string(h.Sum(nil))
-
-	if res, found := c.cache.Get(hashedKey); found {
-		if err, ok := res.(*error); ok {
-			return nil, *err
+		observeUpstreamRequest(request.Method, 0, time.Since(start).Seconds())
+		if errors.Is(context.Cause(ctx), ErrUpstreamTimeout) {
+			err = fmt.Errorf("%w after %s", ErrUpstreamTimeout, h.Timeout)
 		}
-
-		respAsBytes := res.(*[]byte)
-		r := bufio.NewReader(bytes.NewReader(*respAsBytes))
-		slog.Debug("return cached result", "method", request.Method, "host", config.Clusters[0].Cluster.Server, "path", request.Path)
-		return http.ReadResponse(r, nil)
+		cancel(nil)
+		return nil, fmt.Errorf("failed to request api server: %w", err)
 	}
+	observeUpstreamRequest(request.Method, res.StatusCode, time.Since(start).Seconds())
 
-	res, err := c.downstream.RequestApiServerRaw(request, config)
-	if err != nil {
-		c.cache.Set(hashedKey, &err, cache.DefaultExpiration)
-		return nil, err
-	}
-	response, err := httputil.DumpResponse(res, true)
-	if err != nil {
-		return nil, err
-	}
-	c.cache.Set(hashedKey, &response, cache.DefaultExpiration)
+	res.Body = cancelingBody{ReadCloser: res.Body, cancel: cancel}
 	return res, nil
 }
 
-func (c cachingKube) RequestApiGroupsByCategory(config KubeConfig, category string) ([]v2beta1.APIGroupDiscovery, error) {
-	key := fmt.Sprintf("resources %v %s", config, category)
-	if res, found := c.cache.Get(key); found {
-		if err, ok := res.(*error); ok {
-			return nil, *err
-		}
+func (h HttpKube) RequestApiGroupsByCategory(ctx context.Context, config KubeConfig, category string) ([]v2beta1.APIGroupDiscovery, error) {
+	return DiscoverResources(ctx, h, config, ByCategory(category))
+}
 
-		return res.([]v2beta1.APIGroupDiscovery), nil
-	}
+// cancelingBody cancels the context of the request when the response body is closed.
+type cancelingBody struct {
+	io.ReadCloser
+	cancel context.CancelCauseFunc
+}
 
-	res, err := c.downstream.RequestApiGroupsByCategory(config, category)
-	if err != nil {
-		c.cache.Set(key, &err, cache.DefaultExpiration)
-		return nil, err
-	}
-	c.cache.Set(key, res, cache.DefaultExpiration)
-	return res, nil
+func (b cancelingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel(nil)
+	return err
 }
@@ -0,0 +1,81 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHttpKubeAbortsRequests(t *testing.T) {
+	aborted := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow-body" {
+			w.WriteHeader(http.StatusOK)
+			http.NewResponseController(w).Flush()
+			time.Sleep(100 * time.Millisecond)
+			_, _ = io.WriteString(w, "{}")
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			aborted <- struct{}{}
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	config, err := ParseKubeconfig("clusters:\n- cluster:\n    server: " + server.URL + "\nusers:\n- user:\n    token: token\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		kube HttpKube
+		// cancelAfter cancels the context of the request, zero doesn't cancel it
+		cancelAfter time.Duration
+		err         error
+	}{
+		{name: "timeout", kube: HttpKube{Timeout: 50 * time.Millisecond}, err: ErrUpstreamTimeout},
+		{name: "canceled", kube: HttpKube{}, cancelAfter: 50 * time.Millisecond, err: context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if test.cancelAfter > 0 {
+				time.AfterFunc(test.cancelAfter, cancel)
+			}
+
+			start := time.Now()
+			_, err := test.kube.RequestApiServerRaw(ctx, Request{Method: "GET", Path: "/slow-headers"}, config)
+			if !errors.Is(err, test.err) {
+				t.Errorf("expected %v but got %v", test.err, err)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("expected the request to be aborted but it took %s", elapsed)
+			}
+			select {
+			case <-aborted:
+			case <-time.After(time.Second):
+				t.Errorf("expected the api server to see the request aborted")
+			}
+		})
+	}
+
+	t.Run("timeout doesn't cover the body", func(t *testing.T) {
+		res, err := HttpKube{Timeout: 50 * time.Millisecond}.RequestApiServerRaw(context.Background(), Request{Method: "GET", Path: "/slow-body"}, config)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer res.Body.Close()
+		if body, err := io.ReadAll(res.Body); err != nil || string(body) != "{}" {
+			t.Errorf("expected the body but got %q and %v", body, err)
+		}
+	})
+}
@@ -0,0 +1,536 @@
+package k8s
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+	"k8s.io/api/apidiscovery/v2beta1"
+)
+
+var _ CacheAdmin = &cachingKube{}
+
+// CacheStats counts how the requests to a caching Kube were served.
+type CacheStats struct {
+	// Hits is the number of requests served from the cache
+	Hits uint64 `json:"hits"`
+	// Misses is the number of requests which were sent downstream
+	Misses uint64 `json:"misses"`
+	// Coalesced is the number of requests which shared the downstream call of an identical concurrent request
+	Coalesced uint64 `json:"coalesced"`
+	// Entries is the number of cached responses
+	Entries int `json:"entries"`
+	// Bytes is the approximate memory used by the cached responses
+	Bytes int64 `json:"bytes"`
+	// Classes contains the stats per class of cached responses (discovery, controlplanes, secrets and other)
+	Classes map[string]CacheStats `json:"classes,omitempty"`
+}
+
+// CacheStatsProvider is implemented by caching Kubes.
+type CacheStatsProvider interface {
+	Stats() CacheStats
+}
+
+// CacheAdmin is implemented by caching Kubes, to inspect and flush their entries.
+type CacheAdmin interface {
+	CacheStatsProvider
+	// Entries returns the cached entries matching the filter
+	Entries(filter CacheFilter) []CacheEntry
+	// Flush removes the cached entries matching the filter and returns how many were removed
+	Flush(filter CacheFilter) int
+}
+
+// CacheFilter selects cache entries, empty fields match all entries.
+type CacheFilter struct {
+	Class     string
+	Cluster   string
+	Identity  string
+	Namespace string
+}
+
+// CacheEntry describes a cached response without its content.
+type CacheEntry struct {
+	Class   string `json:"class"`
+	Cluster string `json:"cluster"`
+	// Identity is the hashed credential of the caller, e.g. token:<sha256>
+	Identity  string        `json:"identity"`
+	Namespace string        `json:"namespace,omitempty"`
+	Path      string        `json:"path"`
+	Bytes     int64         `json:"bytes"`
+	Age       time.Duration `json:"age"`
+	// ExpiresIn is the time until the entry expires
+	ExpiresIn time.Duration `json:"expiresIn"`
+}
+
+// cacheEntryInfo is stored with every cache entry to describe it.
+type cacheEntryInfo struct {
+	class     string
+	cluster   string
+	identity  string
+	namespace string
+	path      string
+}
+
+func (f CacheFilter) matches(info cacheEntryInfo) bool {
+	return (f.Class == "" || f.Class == info.class) &&
+		(f.Cluster == "" || f.Cluster == info.cluster) &&
+		(f.Identity == "" || f.Identity == info.identity) &&
+		(f.Namespace == "" || f.Namespace == info.namespace)
+}
+
+// CachePolicy configures the memory budget of a caching Kube and how long responses are cached per resource type.
+// A time to live of zero disables caching for the resource type.
+type CachePolicy struct {
+	// MaxBytes is the memory budget of the cache
+	MaxBytes int64
+	// MaxEntryBytes is the size of a single response above which it isn't cached
+	MaxEntryBytes int64
+	// DiscoveryTTL applies to discovery documents (/api, /apis and group versions) and category lookups
+	DiscoveryTTL time.Duration
+	// ControlPlaneTTL applies to managedcontrolplanes
+	ControlPlaneTTL time.Duration
+	// SecretTTL applies to secrets, it should only be set for the kubeconfig lookup of MCPs
+	SecretTTL time.Duration
+	// DefaultTTL applies to all other resources
+	DefaultTTL time.Duration
+	// ErrorTTL applies to failed requests (e.g. connection errors), zero disables caching of errors
+	ErrorTTL time.Duration
+	// ServerErrorTTL applies to 5xx responses, zero disables caching of them
+	ServerErrorTTL time.Duration
+	// NotFoundTTL applies to 404 responses, zero disables caching of them
+	NotFoundTTL time.Duration
+}
+
+const (
+	cacheClassDiscovery     = "discovery"
+	cacheClassControlPlanes = "controlplanes"
+	cacheClassSecrets       = "secrets"
+	cacheClassOther         = "other"
+)
+
+func (p CachePolicy) ttl(class string) time.Duration {
+	switch class {
+	case cacheClassDiscovery:
+		return p.DiscoveryTTL
+	case cacheClassControlPlanes:
+		return p.ControlPlaneTTL
+	case cacheClassSecrets:
+		return p.SecretTTL
+	default:
+		return p.DefaultTTL
+	}
+}
+
+// resultTTL returns how long the result of a request with the given time to live is cached, depending on its outcome.
+// Transient errors shouldn't make a cluster unreachable for the full time to live.
+func (p CachePolicy) resultTTL(ttl time.Duration, statusCode int, err error) time.Duration {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		statusCode = statusErr.Code
+	} else if err != nil {
+		return min(ttl, p.ErrorTTL)
+	}
+
+	switch {
+	case statusCode >= 500:
+		return min(ttl, p.ServerErrorTTL)
+	case statusCode == http.StatusNotFound:
+		return min(ttl, p.NotFoundTTL)
+	default:
+		return ttl
+	}
+}
+
+// pathNamespace returns the namespace of a namespaced resource path, or an empty string.
+func pathNamespace(requestPath string) string {
+	segments := strings.Split(strings.Trim(path.Clean("/"+requestPath), "/"), "/")
+	index := 2
+	if segments[0] == "apis" {
+		index = 3
+	}
+	// the namespace object itself isn't namespaced
+	if len(segments) < index+3 || segments[index] != "namespaces" {
+		return ""
+	}
+	return segments[index+1]
+}
+
+// cacheClass classifies a request path by the resource type it targets.
+func cacheClass(requestPath string) string {
+	segments := strings.Split(strings.Trim(path.Clean("/"+requestPath), "/"), "/")
+
+	var rest []string
+	switch {
+	case segments[0] == "api" && len(segments) <= 2:
+		return cacheClassDiscovery
+	case segments[0] == "api":
+		rest = segments[2:]
+	case segments[0] == "apis" && len(segments) <= 3:
+		return cacheClassDiscovery
+	case segments[0] == "apis":
+		rest = segments[3:]
+	default:
+		return cacheClassOther
+	}
+
+	resource := rest[0]
+	if resource == "namespaces" && len(rest) >= 3 {
+		resource = rest[2]
+	}
+	switch resource {
+	case "secrets":
+		return cacheClassSecrets
+	case "managedcontrolplanes":
+		return cacheClassControlPlanes
+	default:
+		return cacheClassOther
+	}
+}
+
+type cachingKube struct {
+	downstream Kube
+	policy     CachePolicy
+	cache      *lruCache
+	// inFlight deduplicates identical concurrent requests, so only one of them is sent downstream
+	inFlight singleflight.Group
+	// fetches counts the callers waiting for the in-flight request of a key, it is guarded by fetchesMu
+	fetches   map[string]*sharedFetch
+	fetchesMu sync.Mutex
+	// counters has an entry per cache class, it isn't modified after the creation
+	counters map[string]*cacheCounters
+}
+
+// sharedFetch is the context of an in-flight request, which is canceled once no caller waits for it anymore.
+type sharedFetch struct {
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	waiters int
+}
+
+type cacheCounters struct {
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	coalesced atomic.Uint64
+}
+
+func NewCachingKube(downstream Kube, policy CachePolicy) Kube {
+	kube := cachingKube{
+		downstream: downstream,
+		policy:     policy,
+		cache:      newLRUCache(policy.MaxBytes),
+		counters:   make(map[string]*cacheCounters),
+		fetches:    make(map[string]*sharedFetch),
+	}
+	for _, class := range []string{cacheClassDiscovery, cacheClassControlPlanes, cacheClassSecrets, cacheClassOther} {
+		kube.counters[class] = &cacheCounters{}
+	}
+	return &kube
+}
+
+func (c *cachingKube) Stats() CacheStats {
+	stats := CacheStats{Classes: make(map[string]CacheStats)}
+	for class, counters := range c.counters {
+		stats.Classes[class] = CacheStats{
+			Hits:      counters.hits.Load(),
+			Misses:    counters.misses.Load(),
+			Coalesced: counters.coalesced.Load(),
+		}
+	}
+	c.cache.Each(func(entry lruEntry) {
+		classStats := stats.Classes[entry.info.class]
+		classStats.Entries++
+		classStats.Bytes += entry.size
+		stats.Classes[entry.info.class] = classStats
+	})
+
+	for _, classStats := range stats.Classes {
+		stats.Hits += classStats.Hits
+		stats.Misses += classStats.Misses
+		stats.Coalesced += classStats.Coalesced
+		stats.Entries += classStats.Entries
+		stats.Bytes += classStats.Bytes
+	}
+	return stats
+}
+
+func (c *cachingKube) Entries(filter CacheFilter) []CacheEntry {
+	now := time.Now()
+	entries := []CacheEntry{}
+	c.cache.Each(func(entry lruEntry) {
+		if filter.matches(entry.info) {
+			entries = append(entries, CacheEntry{
+				Class:     entry.info.class,
+				Cluster:   entry.info.cluster,
+				Identity:  entry.info.identity,
+				Namespace: entry.info.namespace,
+				Path:      entry.info.path,
+				Bytes:     entry.size,
+				Age:       now.Sub(entry.created),
+				ExpiresIn: entry.expires.Sub(now),
+			})
+		}
+	})
+	return entries
+}
+
+func (c *cachingKube) Flush(filter CacheFilter) int {
+	flushed := c.cache.RemoveFunc(func(entry lruEntry) bool {
+		return filter.matches(entry.info)
+	})
+	slog.Info("flushed cache", "filter", filter, "entries", flushed)
+	return flushed
+}
+
+func (c *cachingKube) RequestApiServerRaw(ctx context.Context, request Request, config KubeConfig) (*http.Response, error) {
+	key, cacheable := requestCacheKey(request, config)
+	info := cacheEntryInfo{
+		class:     cacheClass(request.Path),
+		cluster:   clusterServer(config),
+		identity:  callerIdentity(config),
+		namespace: pathNamespace(request.Path),
+		path:      path.Clean("/" + request.Path),
+	}
+	ttl := c.policy.ttl(info.class)
+	if !cacheable || ttl <= 0 {
+		return c.downstream.RequestApiServerRaw(ctx, request, config)
+	}
+
+	res, err := c.do(ctx, key, info, ttl, func(ctx context.Context) (any, int64, int, error) {
+		res, err := c.downstream.RequestApiServerRaw(ctx, request, config)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		defer res.Body.Close()
+
+		response, err := httputil.DumpResponse(res, true)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		return &response, int64(len(response)), res.StatusCode, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// every caller gets its own response, as the body can only be read once
+	respAsBytes := res.(*[]byte)
+	r := bufio.NewReader(bytes.NewReader(*respAsBytes))
+	return http.ReadResponse(r, nil)
+}
+
+func (c *cachingKube) RequestApiGroupsByCategory(ctx context.Context, config KubeConfig, category string) ([]v2beta1.APIGroupDiscovery, error) {
+	ttl := c.policy.ttl(cacheClassDiscovery)
+	if ttl <= 0 {
+		return c.downstream.RequestApiGroupsByCategory(ctx, config, category)
+	}
+
+	info := cacheEntryInfo{
+		class:    cacheClassDiscovery,
+		cluster:  clusterServer(config),
+		identity: callerIdentity(config),
+		path:     "categories/" + category,
+	}
+	key := hashKey("discovery", info.identity, info.cluster, category)
+	res, err := c.do(ctx, key, info, ttl, func(ctx context.Context) (any, int64, int, error) {
+		res, err := c.downstream.RequestApiGroupsByCategory(ctx, config, category)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		// the size of the encoded form is a good enough approximation of the memory used
+		encoded, err := json.Marshal(res)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		return res, int64(len(encoded)), http.StatusOK, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.([]v2beta1.APIGroupDiscovery), nil
+}
+
+// errorEntrySize is the approximate memory used by a cached error.
+const errorEntrySize = 256
+
+// do returns the cached result for the key. On a cache miss, fetch is called and its result is cached for the ttl
+// (shortened for errors, see CachePolicy.resultTTL), unless it is larger than CachePolicy.MaxEntryBytes.
+// fetch returns the result, its size and the status code of the response. Concurrent calls with the same key wait for
+// the first one instead of calling fetch themselves.
+// As the result of fetch is shared, it isn't canceled with the context of the caller which started it, but once the
+// contexts of all waiting callers are canceled. Every caller stops waiting once its own context is canceled.
+func (c *cachingKube) do(ctx context.Context, key string, info cacheEntryInfo, ttl time.Duration, fetch func(ctx context.Context) (any, int64, int, error)) (any, error) {
+	counters := c.counters[info.class]
+	if res, found := c.cache.Get(key); found {
+		counters.hits.Add(1)
+		if err, ok := res.(*error); ok {
+			return nil, *err
+		}
+		slog.Debug("return cached result", "key", key)
+		return res, nil
+	}
+
+	shared := c.joinFetch(ctx, key)
+	defer c.leaveFetch(ctx, key, shared)
+
+	leader := false
+	results := c.inFlight.DoChan(key, func() (any, error) {
+		leader = true
+		counters.misses.Add(1)
+
+		res, size, statusCode, err := fetch(shared.ctx)
+		if err != nil && shared.ctx.Err() != nil {
+			// the callers are gone, the error is theirs and not the one of the api server
+			return nil, err
+		}
+		ttl := c.policy.resultTTL(ttl, statusCode, err)
+		if err != nil {
+			c.cache.Set(key, &err, errorEntrySize, ttl, info)
+			return nil, err
+		}
+		if size > c.policy.MaxEntryBytes {
+			slog.Debug("response too large to be cached", "key", key, "size", size)
+		} else {
+			c.cache.Set(key, res, size, ttl, info)
+		}
+		return res, nil
+	})
+
+	var result singleflight.Result
+	select {
+	case result = <-results:
+	case <-ctx.Done():
+		return nil, context.Cause(ctx)
+	}
+	if result.Shared && !leader {
+		counters.coalesced.Add(1)
+		slog.Debug("coalesced request", "key", key)
+	}
+
+	return result.Val, result.Err
+}
+
+// joinFetch returns the context of the in-flight request of the key and counts the caller as waiting for it.
+func (c *cachingKube) joinFetch(ctx context.Context, key string) *sharedFetch {
+	c.fetchesMu.Lock()
+	defer c.fetchesMu.Unlock()
+
+	shared, ok := c.fetches[key]
+	if !ok {
+		fetchCtx, cancel := context.WithCancelCause(context.WithoutCancel(ctx))
+		shared = &sharedFetch{ctx: fetchCtx, cancel: cancel}
+		c.fetches[key] = shared
+	}
+	shared.waiters++
+	return shared
+}
+
+// leaveFetch stops counting the caller as waiting. The request is canceled after its last caller left, if it didn't
+// finish yet, and later callers start a new one.
+func (c *cachingKube) leaveFetch(ctx context.Context, key string, shared *sharedFetch) {
+	c.fetchesMu.Lock()
+	defer c.fetchesMu.Unlock()
+
+	shared.waiters--
+	if shared.waiters > 0 {
+		return
+	}
+	delete(c.fetches, key)
+	c.inFlight.Forget(key)
+	shared.cancel(context.Cause(ctx))
+}
+
+// requestCacheKey returns the cache key of a request and whether the request can be cached at all.
+// Only requests which don't change state and return a complete response are cacheable. The key contains the identity of
+// the caller, so cached responses are never shared between callers.
+func requestCacheKey(request Request, config KubeConfig) (string, bool) {
+	method := strings.ToUpper(request.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+	if method != http.MethodGet && method != http.MethodHead {
+		return "", false
+	}
+	if request.hasBody() || len(config.Clusters) == 0 {
+		return "", false
+	}
+	query := url.Values(request.Query)
+	for _, streaming := range []string{"watch", "follow"} {
+		if b, err := strconv.ParseBool(query.Get(streaming)); err == nil && b {
+			return "", false
+		}
+	}
+
+	headerNames := make([]string, 0, len(request.Headers))
+	for k := range request.Headers {
+		if k = http.CanonicalHeaderKey(k); !slices.Contains(traceHeaders, k) {
+			headerNames = append(headerNames, k)
+		}
+	}
+	slices.Sort(headerNames)
+	var headers strings.Builder
+	for _, k := range headerNames {
+		headers.WriteString(k + ": " + strings.Join(http.Header(request.Headers).Values(k), ", ") + "\n")
+	}
+
+	return hashKey(
+		"request",
+		callerIdentity(config),
+		clusterServer(config),
+		method,
+		path.Clean("/"+request.Path),
+		query.Encode(),
+		headers.String(),
+	), true
+}
+
+// callerIdentity identifies the credentials of the first user of the kubeconfig without containing them.
+func callerIdentity(config KubeConfig) string {
+	if len(config.Users) == 0 {
+		return "anonymous"
+	}
+	user := config.Users[0].User
+	switch {
+	case user.Token != "":
+		return "token:" + hashKey(user.Token)
+	case user.ClientCertificateData != "":
+		return "cert:" + hashKey(user.ClientCertificateData, user.ClientKeyData)
+	case user.Username != "":
+		return "basic:" + hashKey(user.Username, user.Password)
+	default:
+		return "anonymous"
+	}
+}
+
+func clusterServer(config KubeConfig) string {
+	if len(config.Clusters) == 0 {
+		return ""
+	}
+	return config.Clusters[0].Cluster.Server
+}
+
+// hashKey hashes the parts with SHA-256. The parts are separated, so different splits of the same string result in different keys.
+func hashKey(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write([]byte(strconv.Itoa(len(part))))
+		h.Write([]byte{':'})
+		h.Write([]byte(part))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
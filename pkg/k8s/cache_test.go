@@ -0,0 +1,299 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRequestCacheKey(t *testing.T) {
+	kubeconfig := func(token string) KubeConfig {
+		config, err := ParseKubeconfig("clusters:\n- cluster:\n    server: https://a\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.SetUserToken(token)
+		return config
+	}
+
+	base := Request{Method: "GET", Path: "/api/v1/secrets", Query: map[string][]string{"limit": {"1"}, "labelSelector": {"a=b"}}}
+	baseKey, cacheable := requestCacheKey(base, kubeconfig("token1"))
+	if !cacheable {
+		t.Fatalf("expected GET request to be cacheable")
+	}
+
+	tests := []struct {
+		name      string
+		request   Request
+		token     string
+		sameKey   bool
+		cacheable bool
+	}{
+		{"same request", base, "token1", true, true},
+		{"query in other order", Request{Method: "GET", Path: "api/v1//secrets", Query: map[string][]string{"labelSelector": {"a=b"}, "limit": {"1"}}}, "token1", true, true},
+		{"other user", base, "token2", false, true},
+		{"other path", Request{Method: "GET", Path: "/api/v1/configmaps", Query: base.Query}, "token1", false, true},
+		{"trace header", Request{Method: "GET", Path: base.Path, Query: base.Query, Headers: map[string][]string{"traceparent": {"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"}}}, "token1", true, true},
+		{"other header", Request{Method: "GET", Path: base.Path, Query: base.Query, Headers: map[string][]string{"Impersonate-User": {"admin"}}}, "token1", false, true},
+		{"post", Request{Method: "POST", Path: base.Path}, "token1", false, false},
+		{"body", Request{Method: "GET", Path: base.Path, Body: strings.NewReader("{}")}, "token1", false, false},
+		{"watch", Request{Method: "GET", Path: base.Path, Query: map[string][]string{"watch": {"true"}}}, "token1", false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key, cacheable := requestCacheKey(test.request, kubeconfig(test.token))
+			if cacheable != test.cacheable {
+				t.Errorf("expected cacheable to be %v but got %v", test.cacheable, cacheable)
+			}
+			if (key == baseKey) != test.sameKey {
+				t.Errorf("expected same key to be %v but got %v", test.sameKey, key == baseKey)
+			}
+			if strings.Contains(key, test.token) {
+				t.Errorf("expected key not to contain the token")
+			}
+		})
+	}
+}
+
+// countingKube blocks every request until release is closed or the request is canceled and counts the requests.
+type countingKube struct {
+	fakeKube
+	requests atomic.Int32
+	canceled atomic.Int32
+	release  chan struct{}
+}
+
+func (c *countingKube) RequestApiServerRaw(ctx context.Context, request Request, config KubeConfig) (*http.Response, error) {
+	c.requests.Add(1)
+	select {
+	case <-c.release:
+	case <-ctx.Done():
+		c.canceled.Add(1)
+		return nil, ctx.Err()
+	}
+	return c.fakeKube.RequestApiServerRaw(ctx, request, config)
+}
+
+func TestCachingKubeCoalescesConcurrentRequests(t *testing.T) {
+	downstream := &countingKube{
+		fakeKube: fakeKube{contentType: "application/json", bodies: map[string]string{"/api/v1/namespaces": `{"items":[]}`}},
+		release:  make(chan struct{}),
+	}
+	kube := NewCachingKube(downstream, CachePolicy{MaxBytes: 1024 * 1024, MaxEntryBytes: 1024, DefaultTTL: time.Minute})
+	config, err := ParseKubeconfig("clusters:\n- cluster:\n    server: https://a\nusers:\n- user:\n    token: token1\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const callers = 5
+	var wg sync.WaitGroup
+	bodies := make([]string, callers)
+	for i := range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := kube.RequestApiServerRaw(context.Background(), Request{Method: "GET", Path: "/api/v1/namespaces"}, config)
+			if err != nil {
+				t.Errorf("expected no error but got: %v", err)
+				return
+			}
+			body, _ := io.ReadAll(res.Body)
+			bodies[i] = string(body)
+		}()
+	}
+
+	// give the callers time to join the in-flight request
+	time.Sleep(50 * time.Millisecond)
+	close(downstream.release)
+	wg.Wait()
+
+	if downstream.requests.Load() != 1 {
+		t.Errorf("expected 1 downstream request but got %d", downstream.requests.Load())
+	}
+	for _, body := range bodies {
+		if body != `{"items":[]}` {
+			t.Errorf("expected every caller to get the body but got %q", body)
+		}
+	}
+	stats := kube.(CacheStatsProvider).Stats()
+	if stats.Misses != 1 || stats.Coalesced != callers-1 {
+		t.Errorf("expected 1 miss and %d coalesced requests but got %+v", callers-1, stats)
+	}
+}
+
+func TestCachingKubeCanceledCaller(t *testing.T) {
+	config, err := ParseKubeconfig("clusters:\n- cluster:\n    server: https://a\nusers:\n- user:\n    token: token1\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := Request{Method: "GET", Path: "/api/v1/namespaces"}
+	newKube := func() (*countingKube, Kube) {
+		downstream := &countingKube{
+			fakeKube: fakeKube{contentType: "application/json", bodies: map[string]string{"/api/v1/namespaces": `{"items":[]}`}},
+			release:  make(chan struct{}),
+		}
+		return downstream, NewCachingKube(downstream, CachePolicy{MaxBytes: 1024 * 1024, MaxEntryBytes: 1024, DefaultTTL: time.Minute})
+	}
+
+	t.Run("other callers keep the request", func(t *testing.T) {
+		downstream, kube := newKube()
+		result := make(chan error)
+		go func() {
+			_, err := kube.RequestApiServerRaw(context.Background(), request, config)
+			result <- err
+		}()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+		if _, err := kube.RequestApiServerRaw(ctx, request, config); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected the canceled caller to get context.Canceled but got %v", err)
+		}
+
+		close(downstream.release)
+		if err := <-result; err != nil {
+			t.Errorf("expected the remaining caller to get the response but got %v", err)
+		}
+		if downstream.requests.Load() != 1 || downstream.canceled.Load() != 0 {
+			t.Errorf("expected 1 downstream request which isn't canceled but got %d requests and %d canceled", downstream.requests.Load(), downstream.canceled.Load())
+		}
+	})
+
+	t.Run("last caller cancels the request", func(t *testing.T) {
+		downstream, kube := newKube()
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+		if _, err := kube.RequestApiServerRaw(ctx, request, config); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected the canceled caller to get context.Canceled but got %v", err)
+		}
+
+		for deadline := time.Now().Add(5 * time.Second); downstream.canceled.Load() == 0 && time.Now().Before(deadline); {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if downstream.canceled.Load() != 1 {
+			t.Fatalf("expected the downstream request to be canceled")
+		}
+
+		// the cancellation isn't cached, the next caller sends a new request
+		close(downstream.release)
+		if _, err := kube.RequestApiServerRaw(context.Background(), request, config); err != nil {
+			t.Fatal(err)
+		}
+		if downstream.requests.Load() != 2 {
+			t.Errorf("expected 2 downstream requests but got %d", downstream.requests.Load())
+		}
+	})
+}
+
+func TestCachingKubeFlush(t *testing.T) {
+	downstream := fakeKube{contentType: "application/json", bodies: map[string]string{
+		"/api/v1/namespaces":                       `{"items":[]}`,
+		"/api/v1/namespaces/project-a/secrets/mcp": `{}`,
+	}}
+	kube := NewCachingKube(downstream, CachePolicy{MaxBytes: 1024 * 1024, MaxEntryBytes: 1024, SecretTTL: time.Minute, DefaultTTL: time.Minute})
+	admin := kube.(CacheAdmin)
+	configA, _ := ParseKubeconfig("clusters:\n- cluster:\n    server: https://a\nusers:\n- user:\n    token: token1\n")
+	configB, _ := ParseKubeconfig("clusters:\n- cluster:\n    server: https://b\nusers:\n- user:\n    token: token1\n")
+	for _, config := range []KubeConfig{configA, configB} {
+		for path := range downstream.bodies {
+			if _, err := kube.RequestApiServerRaw(context.Background(), Request{Method: "GET", Path: path}, config); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if entries := admin.Entries(CacheFilter{Class: cacheClassSecrets}); len(entries) != 2 {
+		t.Errorf("expected 2 cached secrets but got %+v", entries)
+	}
+	if flushed := admin.Flush(CacheFilter{Cluster: "https://a"}); flushed != 2 {
+		t.Errorf("expected 2 flushed entries but got %d", flushed)
+	}
+	entries := admin.Entries(CacheFilter{})
+	if len(entries) != 2 || entries[0].Cluster != "https://b" || entries[1].Cluster != "https://b" {
+		t.Errorf("expected only the entries of cluster b to remain but got %+v", entries)
+	}
+	if stats := admin.Stats(); stats.Entries != 2 || stats.Classes[cacheClassSecrets].Entries != 1 {
+		t.Errorf("expected 2 entries with 1 secret but got %+v", stats)
+	}
+}
+
+func TestCacheClass(t *testing.T) {
+	tests := []struct {
+		path  string
+		class string
+	}{
+		{"/api", cacheClassDiscovery},
+		{"/api/v1", cacheClassDiscovery},
+		{"/apis", cacheClassDiscovery},
+		{"/apis/core.openmcp.cloud/v1alpha1", cacheClassDiscovery},
+		{"/api/v1/namespaces/project-a/secrets/kubeconfig", cacheClassSecrets},
+		{"/api/v1/secrets", cacheClassSecrets},
+		{"/apis/core.openmcp.cloud/v1alpha1/namespaces/project-a/managedcontrolplanes/mcp", cacheClassControlPlanes},
+		{"/api/v1/namespaces/secrets", cacheClassOther},
+		{"/api/v1/namespaces/project-a/pods", cacheClassOther},
+		{"/version", cacheClassOther},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			if class := cacheClass(test.path); class != test.class {
+				t.Errorf("expected %s but got %s", test.class, class)
+			}
+		})
+	}
+}
+
+func TestLRUCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newLRUCache(10)
+	cache.Set("a", "a", 4, time.Minute, cacheEntryInfo{})
+	cache.Set("b", "b", 4, time.Minute, cacheEntryInfo{})
+	cache.Get("a")
+	cache.Set("c", "c", 4, time.Minute, cacheEntryInfo{})
+
+	if _, found := cache.Get("b"); found {
+		t.Errorf("expected b to be evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, found := cache.Get(key); !found {
+			t.Errorf("expected %s to be cached", key)
+		}
+	}
+	if cache.Set("d", "d", 11, time.Minute, cacheEntryInfo{}) {
+		t.Errorf("expected entry larger than the budget not to be stored")
+	}
+	if entries, bytes := cache.Len(); entries != 2 || bytes != 8 {
+		t.Errorf("expected 2 entries with 8 bytes but got %d entries with %d bytes", entries, bytes)
+	}
+}
+
+func TestCachePolicyResultTTL(t *testing.T) {
+	policy := CachePolicy{ErrorTTL: time.Second, ServerErrorTTL: 0, NotFoundTTL: 5 * time.Second}
+
+	tests := []struct {
+		name       string
+		statusCode int
+		err        error
+		ttl        time.Duration
+	}{
+		{"success", http.StatusOK, nil, time.Minute},
+		{"forbidden", http.StatusForbidden, nil, time.Minute},
+		{"not found", http.StatusNotFound, nil, 5 * time.Second},
+		{"server error", http.StatusServiceUnavailable, nil, 0},
+		{"status error", 0, &StatusError{Code: http.StatusInternalServerError}, 0},
+		{"connection error", 0, errors.New("connection refused"), time.Second},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if ttl := policy.resultTTL(time.Minute, test.statusCode, test.err); ttl != test.ttl {
+				t.Errorf("expected %v but got %v", test.ttl, ttl)
+			}
+		})
+	}
+}
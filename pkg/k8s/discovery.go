@@ -0,0 +1,255 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"k8s.io/api/apidiscovery/v2beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// aggregatedDiscoveryAccept prefers aggregated discovery (v2, then v2beta1) and falls back to legacy discovery for older api servers.
+const aggregatedDiscoveryAccept = "application/json;g=apidiscovery.k8s.io;v=v2;as=APIGroupDiscoveryList,application/json;g=apidiscovery.k8s.io;v=v2beta1;as=APIGroupDiscoveryList,application/json"
+
+// ResourceFilter decides whether a resource of a group version is part of the discovery result.
+type ResourceFilter func(group, version string, resource v2beta1.APIResourceDiscovery) bool
+
+// ByCategory selects the resources which are part of the given category, e.g. "managed" or "all".
+func ByCategory(category string) ResourceFilter {
+	return func(_, _ string, resource v2beta1.APIResourceDiscovery) bool {
+		for _, cat := range resource.Categories {
+			if cat == category {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// GroupVersionPath returns the path of a group version, e.g. /api/v1 for the core group or /apis/apps/v1 otherwise.
+func GroupVersionPath(group, version string) string {
+	if group == "" {
+		return "/api/" + version
+	}
+	return "/apis/" + group + "/" + version
+}
+
+// ResourcePath returns the path of a resource, e.g. /api/v1/pods for the core group or /apis/apps/v1/deployments otherwise.
+func ResourcePath(group, version, resource string) string {
+	return GroupVersionPath(group, version) + "/" + resource
+}
+
+// DiscoverResources returns the groups, versions and resources of the core group (/api) and the named groups (/apis),
+// which match the filter. Groups and versions without matching resources are omitted.
+// Aggregated discovery (apidiscovery.k8s.io v2 and v2beta1) is used if the api server supports it, otherwise every
+// group version is discovered separately.
+func DiscoverResources(ctx context.Context, kube Kube, config KubeConfig, filter ResourceFilter) ([]v2beta1.APIGroupDiscovery, error) {
+	var groups []v2beta1.APIGroupDiscovery
+	for _, path := range []string{"/api", "/apis"} {
+		discovered, err := discoverGroups(ctx, kube, config, path)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, discovered...)
+	}
+
+	var filteredItems []v2beta1.APIGroupDiscovery
+	for _, item := range groups {
+		filteredVersions := []v2beta1.APIVersionDiscovery{}
+		for _, version := range item.Versions {
+			filteredVersion := version
+			filteredResources := []v2beta1.APIResourceDiscovery{}
+			for _, resource := range version.Resources {
+				if filter == nil || filter(item.Name, version.Version, resource) {
+					filteredResources = append(filteredResources, resource)
+				}
+			}
+
+			if len(filteredResources) > 0 {
+				filteredVersion.Resources = filteredResources
+				filteredVersions = append(filteredVersions, filteredVersion)
+			}
+		}
+
+		if len(filteredVersions) > 0 {
+			item.Versions = filteredVersions
+			filteredItems = append(filteredItems, item)
+		}
+	}
+
+	return filteredItems, nil
+}
+
+// discoverGroups discovers the groups served below /api or /apis.
+func discoverGroups(ctx context.Context, kube Kube, config KubeConfig, path string) ([]v2beta1.APIGroupDiscovery, error) {
+	body, contentType, err := requestDiscovery(ctx, kube, config, path, aggregatedDiscoveryAccept)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.Contains(contentType, "g=apidiscovery.k8s.io") {
+		// v2 and v2beta1 share the same schema
+		var list v2beta1.APIGroupDiscoveryList
+		if err := json.Unmarshal(body, &list); err != nil {
+			return nil, fmt.Errorf("failed to decode aggregated discovery of %s: %v", path, err)
+		}
+		return list.Items, nil
+	}
+
+	var groupVersions []metav1.GroupVersionForDiscovery
+	if path == "/api" {
+		var versions metav1.APIVersions
+		if err := json.Unmarshal(body, &versions); err != nil {
+			return nil, fmt.Errorf("failed to decode discovery of %s: %v", path, err)
+		}
+		for _, version := range versions.Versions {
+			groupVersions = append(groupVersions, metav1.GroupVersionForDiscovery{Version: version})
+		}
+		return discoverLegacyGroups(ctx, kube, config, []metav1.APIGroup{{Versions: groupVersions}})
+	}
+
+	var groupList metav1.APIGroupList
+	if err := json.Unmarshal(body, &groupList); err != nil {
+		return nil, fmt.Errorf("failed to decode discovery of %s: %v", path, err)
+	}
+	return discoverLegacyGroups(ctx, kube, config, groupList.Groups)
+}
+
+// legacyDiscoveryConcurrency is the maximum number of group versions discovered at the same time, so api servers
+// without aggregated discovery aren't flooded with requests on clusters with many CRD groups.
+const legacyDiscoveryConcurrency = 10
+
+// discoverLegacyGroups requests the resources of every group version concurrently, using at most
+// legacyDiscoveryConcurrency requests at a time. Group versions which can't be discovered (e.g. because an aggregated
+// api server is unavailable) are skipped, like kubectl does.
+func discoverLegacyGroups(ctx context.Context, kube Kube, config KubeConfig, groups []metav1.APIGroup) ([]v2beta1.APIGroupDiscovery, error) {
+	type groupVersion struct {
+		group, version int
+	}
+	var groupVersions []groupVersion
+	items := make([]v2beta1.APIGroupDiscovery, len(groups))
+	for i, group := range groups {
+		items[i].Name = group.Name
+		items[i].Versions = make([]v2beta1.APIVersionDiscovery, len(group.Versions))
+		for j, version := range group.Versions {
+			items[i].Versions[j].Version = version.Version
+			items[i].Versions[j].Freshness = v2beta1.DiscoveryFreshnessCurrent
+			groupVersions = append(groupVersions, groupVersion{group: i, version: j})
+		}
+	}
+
+	jobs := make(chan groupVersion)
+	go func() {
+		defer close(jobs)
+		for _, job := range groupVersions {
+			jobs <- job
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for range min(legacyDiscoveryConcurrency, len(groupVersions)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				discoverLegacyGroupVersion(ctx, kube, config, &items[job.group], &items[job.group].Versions[job.version])
+			}
+		}()
+	}
+	wg.Wait()
+
+	return items, nil
+}
+
+// discoverLegacyGroupVersion requests the resources of a single group version. If they can't be discovered, the
+// version is marked as stale.
+func discoverLegacyGroupVersion(ctx context.Context, kube Kube, config KubeConfig, group *v2beta1.APIGroupDiscovery, version *v2beta1.APIVersionDiscovery) {
+	path := GroupVersionPath(group.Name, version.Version)
+	body, _, err := requestDiscovery(ctx, kube, config, path, "application/json")
+	if err != nil {
+		slog.Warn("failed to discover group version", "path", path, "err", err)
+		version.Freshness = v2beta1.DiscoveryFreshnessStale
+		return
+	}
+
+	var resourceList metav1.APIResourceList
+	if err := json.Unmarshal(body, &resourceList); err != nil {
+		slog.Warn("failed to decode discovery of group version", "path", path, "err", err)
+		version.Freshness = v2beta1.DiscoveryFreshnessStale
+		return
+	}
+	version.Resources = convertLegacyResources(group.Name, version.Version, resourceList.APIResources)
+}
+
+// convertLegacyResources converts the resources of a legacy discovery document into their aggregated discovery form.
+func convertLegacyResources(group, version string, apiResources []metav1.APIResource) []v2beta1.APIResourceDiscovery {
+	var resources []v2beta1.APIResourceDiscovery
+	subresources := map[string][]v2beta1.APISubresourceDiscovery{}
+	for _, r := range apiResources {
+		gvk := &metav1.GroupVersionKind{Group: group, Version: version, Kind: r.Kind}
+		if r.Group != "" {
+			gvk.Group = r.Group
+		}
+		if r.Version != "" {
+			gvk.Version = r.Version
+		}
+
+		if parent, subresource, ok := strings.Cut(r.Name, "/"); ok {
+			subresources[parent] = append(subresources[parent], v2beta1.APISubresourceDiscovery{
+				Subresource:  subresource,
+				ResponseKind: gvk,
+				Verbs:        r.Verbs,
+			})
+			continue
+		}
+
+		scope := v2beta1.ScopeCluster
+		if r.Namespaced {
+			scope = v2beta1.ScopeNamespace
+		}
+		resources = append(resources, v2beta1.APIResourceDiscovery{
+			Resource:         r.Name,
+			ResponseKind:     gvk,
+			Scope:            scope,
+			SingularResource: r.SingularName,
+			Verbs:            r.Verbs,
+			ShortNames:       r.ShortNames,
+			Categories:       r.Categories,
+		})
+	}
+
+	for i := range resources {
+		resources[i].Subresources = subresources[resources[i].Resource]
+	}
+	return resources
+}
+
+func requestDiscovery(ctx context.Context, kube Kube, config KubeConfig, path, accept string) ([]byte, string, error) {
+	res, err := kube.RequestApiServerRaw(ctx, Request{
+		Method: "GET",
+		Path:   path,
+		Headers: map[string][]string{
+			"Accept": {accept},
+		},
+	}, config)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if res.StatusCode >= 400 {
+		return nil, "", NewStatusError(res.StatusCode, body)
+	}
+
+	return body, res.Header.Get("Content-Type"), nil
+}
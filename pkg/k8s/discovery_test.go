@@ -0,0 +1,132 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"k8s.io/api/apidiscovery/v2beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeKube answers requests with the bodies registered for their paths.
+type fakeKube struct {
+	contentType string
+	bodies      map[string]string
+}
+
+func (f fakeKube) RequestApiServerRaw(_ context.Context, request Request, _ KubeConfig) (*http.Response, error) {
+	body, ok := f.bodies[request.Path]
+	if !ok {
+		return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(strings.NewReader(`{"kind":"Status","message":"not found"}`))}, nil
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {f.contentType}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}, nil
+}
+
+func (f fakeKube) RequestApiGroupsByCategory(ctx context.Context, config KubeConfig, category string) ([]v2beta1.APIGroupDiscovery, error) {
+	return DiscoverResources(ctx, f, config, ByCategory(category))
+}
+
+func TestDiscoverResources(t *testing.T) {
+	tests := []struct {
+		name string
+		kube fakeKube
+	}{
+		{
+			name: "aggregated v2",
+			kube: fakeKube{
+				contentType: "application/json;g=apidiscovery.k8s.io;v=v2;as=APIGroupDiscoveryList",
+				bodies: map[string]string{
+					"/api":  `{"items":[{"metadata":{},"versions":[{"version":"v1","resources":[{"resource":"pods","responseKind":{"version":"v1","kind":"Pod"},"scope":"Namespaced","categories":["all"]},{"resource":"secrets","scope":"Namespaced"}]}]}]}`,
+					"/apis": `{"items":[{"metadata":{"name":"apps"},"versions":[{"version":"v1","resources":[{"resource":"deployments","responseKind":{"group":"apps","version":"v1","kind":"Deployment"},"scope":"Namespaced","categories":["all"]}]}]}]}`,
+				},
+			},
+		},
+		{
+			name: "legacy",
+			kube: fakeKube{
+				contentType: "application/json",
+				bodies: map[string]string{
+					"/api":          `{"versions":["v1"]}`,
+					"/apis":         `{"groups":[{"name":"apps","versions":[{"groupVersion":"apps/v1","version":"v1"}]},{"name":"unavailable.io","versions":[{"groupVersion":"unavailable.io/v1","version":"v1"}]}]}`,
+					"/api/v1":       `{"groupVersion":"v1","resources":[{"name":"pods","namespaced":true,"kind":"Pod","categories":["all"]},{"name":"pods/log","namespaced":true,"kind":"Pod"},{"name":"secrets","namespaced":true,"kind":"Secret"}]}`,
+					"/apis/apps/v1": `{"groupVersion":"apps/v1","resources":[{"name":"deployments","namespaced":true,"kind":"Deployment","categories":["all"]}]}`,
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			groups, err := test.kube.RequestApiGroupsByCategory(context.Background(), KubeConfig{}, "all")
+			if err != nil {
+				t.Fatalf("expected no error but got: %v", err)
+			}
+
+			var paths []string
+			for _, group := range groups {
+				for _, version := range group.Versions {
+					for _, resource := range version.Resources {
+						paths = append(paths, ResourcePath(group.Name, version.Version, resource.Resource)+" "+resource.ResponseKind.Kind)
+					}
+				}
+			}
+
+			expected := "/api/v1/pods Pod,/apis/apps/v1/deployments Deployment"
+			if strings.Join(paths, ",") != expected {
+				t.Errorf("expected resources to be %q but got %q", expected, strings.Join(paths, ","))
+			}
+		})
+	}
+}
+
+// slowKube answers every request after a delay and counts the concurrent requests.
+type slowKube struct {
+	fakeKube
+	inFlight, maxInFlight atomic.Int32
+}
+
+func (s *slowKube) RequestApiServerRaw(ctx context.Context, request Request, config KubeConfig) (*http.Response, error) {
+	inFlight := s.inFlight.Add(1)
+	defer s.inFlight.Add(-1)
+	for {
+		maxInFlight := s.maxInFlight.Load()
+		if inFlight <= maxInFlight || s.maxInFlight.CompareAndSwap(maxInFlight, inFlight) {
+			break
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	return s.fakeKube.RequestApiServerRaw(ctx, request, config)
+}
+
+func TestDiscoverLegacyGroupsLimitsConcurrency(t *testing.T) {
+	kube := &slowKube{fakeKube: fakeKube{contentType: "application/json", bodies: map[string]string{}}}
+	var groups []metav1.APIGroup
+	for i := range 3 * legacyDiscoveryConcurrency {
+		name := fmt.Sprintf("group%d.example.com", i)
+		groups = append(groups, metav1.APIGroup{Name: name, Versions: []metav1.GroupVersionForDiscovery{{Version: "v1"}}})
+		kube.bodies[GroupVersionPath(name, "v1")] = `{"resources":[{"name":"things","namespaced":true,"kind":"Thing"}]}`
+	}
+
+	items, err := discoverLegacyGroups(context.Background(), kube, KubeConfig{}, groups)
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	for _, item := range items {
+		if len(item.Versions[0].Resources) != 1 {
+			t.Errorf("expected the resources of %s but got %+v", item.Name, item.Versions)
+		}
+	}
+	if actual := kube.maxInFlight.Load(); actual != legacyDiscoveryConcurrency {
+		t.Errorf("expected at most %d concurrent requests but got %d", legacyDiscoveryConcurrency, actual)
+	}
+}
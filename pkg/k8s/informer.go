@@ -0,0 +1,504 @@
+package k8s
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/api/apidiscovery/v2beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+// InformerPolicy configures which resources an InformerCache serves and how long informers and access reviews are kept.
+type InformerPolicy struct {
+	// Resources are patterns of the resources served from informers in the form resource.group, e.g.
+	// managedcontrolplanes.core.openmcp.cloud or *.*.upbound.io. Resources of the core group have no group suffix.
+	Resources []string
+	// IdleTimeout is the time after which an informer which wasn't used is stopped
+	IdleTimeout time.Duration
+	// SyncTimeout is the time a request waits for a new informer to be synced before it is sent to the api server instead
+	SyncTimeout time.Duration
+	// AccessReviewTTL is the time the result of a SelfSubjectAccessReview of a user is cached
+	AccessReviewTTL time.Duration
+}
+
+// accessReviewCacheBytes is the memory budget for cached access reviews, which are a few bytes each.
+const accessReviewCacheBytes = 4 * 1024 * 1024
+
+// InformerCache keeps the lists of frequently requested resources warm with informers and serves GET and LIST requests
+// from them. Informers are shared between users and run with the credentials of the service kubeconfig of a cluster,
+// so every request is authorized with a SelfSubjectAccessReview of the user first.
+type InformerCache struct {
+	kube          Kube
+	policy        InformerPolicy
+	accessReviews *lruCache
+
+	mu        sync.Mutex
+	informers map[string]*resourceInformer
+	stop      chan struct{}
+}
+
+type resourceInformer struct {
+	server   string
+	gvr      schema.GroupVersionResource
+	informer cache.SharedIndexInformer
+	cancel   context.CancelFunc
+
+	mu         sync.Mutex
+	lastUsed   time.Time
+	apiVersion string
+	listKind   string
+}
+
+// NewInformerCache creates an InformerCache, which uses the Kube for the access reviews of the users.
+func NewInformerCache(kube Kube, policy InformerPolicy) *InformerCache {
+	c := &InformerCache{
+		kube:          kube,
+		policy:        policy,
+		accessReviews: newLRUCache(accessReviewCacheBytes),
+		informers:     make(map[string]*resourceInformer),
+		stop:          make(chan struct{}),
+	}
+	go c.stopIdleInformers()
+	return c
+}
+
+// Stop stops all informers.
+func (c *InformerCache) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	close(c.stop)
+	for key, informer := range c.informers {
+		informer.cancel()
+		delete(c.informers, key)
+	}
+}
+
+// Lookup answers a GET or LIST request from an informer like the api server would. If the request can't be served
+// locally (e.g. the resource isn't configured, the request uses unsupported parameters or the informer isn't synced
+// yet), false is returned and the request has to be sent to the api server.
+// The service kubeconfig is used for the informer, the user kubeconfig for the access review.
+func (c *InformerCache) Lookup(ctx context.Context, request Request, serviceConfig, userConfig KubeConfig) (*http.Response, bool) {
+	target, ok := parseResourcePath(request.Path)
+	if !ok || !c.serves(target.gvr) || !isInformerRequest(request) || !hasCredentials(serviceConfig) {
+		return nil, false
+	}
+	selector, err := labels.Parse(url.Values(request.Query).Get("labelSelector"))
+	if err != nil {
+		return nil, false
+	}
+
+	informer, err := c.informer(ctx, serviceConfig, target.gvr)
+	if err != nil {
+		slog.Warn("failed to start informer", "resource", target.gvr.String(), "err", err)
+		return nil, false
+	}
+	if !informer.informer.HasSynced() {
+		return nil, false
+	}
+
+	verb := "list"
+	if target.name != "" {
+		verb = "get"
+	}
+	allowed, err := c.accessAllowed(ctx, userConfig, verb, target)
+	if err != nil {
+		slog.Warn("failed to review access", "resource", target.gvr.String(), "err", err)
+		return nil, false
+	}
+	if !allowed {
+		return statusResponse(http.StatusForbidden, metav1.StatusReasonForbidden, fmt.Sprintf("%s is forbidden: the user cannot %s the resource in namespace %q", target.groupResource(), verb, target.namespace)), true
+	}
+
+	if target.name != "" {
+		return informer.get(target)
+	}
+	return informer.list(target, selector)
+}
+
+func (c *InformerCache) serves(gvr schema.GroupVersionResource) bool {
+	name := gvr.Resource
+	if gvr.Group != "" {
+		name += "." + gvr.Group
+	}
+	for _, pattern := range c.policy.Resources {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// isInformerRequest returns whether the request can be answered from an informer. Requests for a specific
+// resourceVersion, pages, field selectors, watches and other formats than JSON are sent to the api server.
+func isInformerRequest(request Request) bool {
+	if request.Method != http.MethodGet || request.hasBody() {
+		return false
+	}
+	for parameter := range request.Query {
+		if parameter != "labelSelector" && parameter != "pretty" {
+			return false
+		}
+	}
+	accept := http.Header(request.Headers).Get("Accept")
+	return accept == "" || accept == "*/*" || (strings.HasPrefix(accept, "application/json") && !strings.Contains(accept, ";as="))
+}
+
+func hasCredentials(config KubeConfig) bool {
+	if len(config.Clusters) == 0 || len(config.Users) == 0 {
+		return false
+	}
+	user := config.Users[0].User
+	return user.Token != "" || user.ClientCertificateData != ""
+}
+
+// informer returns the informer of the resource on the cluster, starting it if necessary. A new informer is given
+// InformerPolicy.SyncTimeout to sync.
+func (c *InformerCache) informer(ctx context.Context, serviceConfig KubeConfig, gvr schema.GroupVersionResource) (*resourceInformer, error) {
+	key := hashKey("informer", clusterServer(serviceConfig), callerIdentity(serviceConfig), gvr.Group, gvr.Version, gvr.Resource)
+
+	c.mu.Lock()
+	informer, ok := c.informers[key]
+	if !ok {
+		var err error
+		informer, err = newResourceInformer(serviceConfig, gvr)
+		if err != nil {
+			c.mu.Unlock()
+			return nil, err
+		}
+		c.informers[key] = informer
+	}
+	c.mu.Unlock()
+
+	informer.mu.Lock()
+	informer.lastUsed = time.Now()
+	informer.mu.Unlock()
+
+	if !ok {
+		slog.Debug("started informer", "host", clusterServer(serviceConfig), "resource", gvr.String())
+		ctx, cancel := context.WithTimeout(ctx, c.policy.SyncTimeout)
+		defer cancel()
+		cache.WaitForCacheSync(ctx.Done(), informer.informer.HasSynced)
+	}
+	return informer, nil
+}
+
+func newResourceInformer(serviceConfig KubeConfig, gvr schema.GroupVersionResource) (*resourceInformer, error) {
+	restConfig, err := newRestConfig(serviceConfig)
+	if err != nil {
+		return nil, err
+	}
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	informer := &resourceInformer{server: serviceConfig.Clusters[0].Cluster.Server, gvr: gvr, cancel: cancel}
+	informer.informer = cache.NewSharedIndexInformer(&cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			list, err := client.Resource(gvr).List(ctx, options)
+			if err == nil {
+				// the kind is needed for empty lists, as it can't be taken from the items
+				informer.mu.Lock()
+				informer.apiVersion, informer.listKind = list.GetAPIVersion(), list.GetKind()
+				informer.mu.Unlock()
+			}
+			return list, err
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return client.Resource(gvr).Watch(ctx, options)
+		},
+	}, &unstructured.Unstructured{}, 0, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+
+	go informer.informer.Run(ctx.Done())
+	return informer, nil
+}
+
+// newRestConfig converts the kubeconfig for client-go, like NewTLSConfig does for the HTTP transports.
+func newRestConfig(config KubeConfig) (*rest.Config, error) {
+	cluster := config.Clusters[0].Cluster
+	user := config.Users[0].User
+
+	restConfig := &rest.Config{
+		Host:        cluster.Server,
+		BearerToken: user.Token,
+	}
+	for _, field := range []struct {
+		data   string
+		target *[]byte
+	}{
+		{cluster.CertificateAuthorityData, &restConfig.CAData},
+		{user.ClientCertificateData, &restConfig.CertData},
+		{user.ClientKeyData, &restConfig.KeyData},
+	} {
+		if field.data == "" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(field.data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode kubeconfig data: %v", err)
+		}
+		*field.target = decoded
+	}
+	return restConfig, nil
+}
+
+// stopIdleInformers stops the informers which weren't used for InformerPolicy.IdleTimeout.
+func (c *InformerCache) stopIdleInformers() {
+	ticker := time.NewTicker(max(c.policy.IdleTimeout/2, time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case now := <-ticker.C:
+			c.mu.Lock()
+			for key, informer := range c.informers {
+				informer.mu.Lock()
+				idle := now.Sub(informer.lastUsed) > c.policy.IdleTimeout
+				informer.mu.Unlock()
+				if idle {
+					slog.Debug("stopping idle informer", "host", informer.server, "resource", informer.gvr.String())
+					informer.cancel()
+					delete(c.informers, key)
+				}
+			}
+			c.mu.Unlock()
+		}
+	}
+}
+
+// accessAllowed reviews with a SelfSubjectAccessReview whether the user is allowed to get or list the resource.
+func (c *InformerCache) accessAllowed(ctx context.Context, userConfig KubeConfig, verb string, target resourceTarget) (bool, error) {
+	key := hashKey("accessreview", callerIdentity(userConfig), clusterServer(userConfig), verb, target.gvr.Group, target.gvr.Resource, target.namespace, target.name)
+	if allowed, found := c.accessReviews.Get(key); found {
+		return allowed.(bool), nil
+	}
+
+	review := map[string]any{
+		"apiVersion": "authorization.k8s.io/v1",
+		"kind":       "SelfSubjectAccessReview",
+		"spec": map[string]any{
+			"resourceAttributes": map[string]string{
+				"namespace": target.namespace,
+				"verb":      verb,
+				"group":     target.gvr.Group,
+				"version":   target.gvr.Version,
+				"resource":  target.gvr.Resource,
+				"name":      target.name,
+			},
+		},
+	}
+	body, err := json.Marshal(review)
+	if err != nil {
+		return false, err
+	}
+
+	var result struct {
+		Status struct {
+			Allowed bool `json:"allowed"`
+		} `json:"status"`
+	}
+	err = RequestApiServer(ctx, c.kube, Request{
+		Method: http.MethodPost,
+		Path:   "/apis/authorization.k8s.io/v1/selfsubjectaccessreviews",
+		Body:   bytes.NewReader(body),
+	}, userConfig, &result)
+	if err != nil {
+		return false, err
+	}
+
+	c.accessReviews.Set(key, result.Status.Allowed, int64(len(key)), c.policy.AccessReviewTTL, cacheEntryInfo{})
+	return result.Status.Allowed, nil
+}
+
+func (i *resourceInformer) get(target resourceTarget) (*http.Response, bool) {
+	key := target.name
+	if target.namespace != "" {
+		key = target.namespace + "/" + target.name
+	}
+	obj, exists, err := i.informer.GetIndexer().GetByKey(key)
+	if err != nil {
+		return nil, false
+	}
+	if !exists {
+		return statusResponse(http.StatusNotFound, metav1.StatusReasonNotFound, fmt.Sprintf("%s %q not found", target.groupResource(), target.name)), true
+	}
+	return jsonResponse(obj)
+}
+
+// list returns the objects of the namespace (or all namespaces) which match the selector, sorted by namespace and name like
+// the api server does.
+func (i *resourceInformer) list(target resourceTarget, selector labels.Selector) (*http.Response, bool) {
+	var objs []any
+	var err error
+	if target.namespace != "" {
+		objs, err = i.informer.GetIndexer().ByIndex(cache.NamespaceIndex, target.namespace)
+	} else {
+		objs = i.informer.GetStore().List()
+	}
+	if err != nil {
+		return nil, false
+	}
+
+	items := make([]map[string]any, 0, len(objs))
+	for _, obj := range objs {
+		item := obj.(*unstructured.Unstructured)
+		if selector.Matches(labels.Set(item.GetLabels())) {
+			items = append(items, item.Object)
+		}
+	}
+	slices.SortFunc(items, func(a, b map[string]any) int {
+		return strings.Compare(objectKey(a), objectKey(b))
+	})
+
+	i.mu.Lock()
+	apiVersion, listKind := i.apiVersion, i.listKind
+	i.mu.Unlock()
+
+	return jsonResponse(map[string]any{
+		"apiVersion": apiVersion,
+		"kind":       listKind,
+		"metadata": map[string]any{
+			"resourceVersion": i.informer.LastSyncResourceVersion(),
+		},
+		"items": items,
+	})
+}
+
+func objectKey(obj map[string]any) string {
+	namespace, _, _ := unstructured.NestedString(obj, "metadata", "namespace")
+	name, _, _ := unstructured.NestedString(obj, "metadata", "name")
+	return namespace + "/" + name
+}
+
+func jsonResponse(obj any) (*http.Response, bool) {
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return nil, false
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(body)),
+	}, true
+}
+
+func statusResponse(code int, reason metav1.StatusReason, message string) *http.Response {
+	body, _ := json.Marshal(metav1.Status{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Status"},
+		Status:   metav1.StatusFailure,
+		Message:  message,
+		Reason:   reason,
+		Code:     int32(code),
+	})
+	return &http.Response{
+		StatusCode: code,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(body)),
+	}
+}
+
+// resourceTarget is the resource, namespace and name a request path refers to.
+type resourceTarget struct {
+	gvr       schema.GroupVersionResource
+	namespace string
+	name      string
+}
+
+func (t resourceTarget) groupResource() string {
+	return t.gvr.GroupResource().String()
+}
+
+// namespaceSubresources are the subresources of namespaces, whose paths look like the lists of namespaced resources.
+var namespaceSubresources = []string{"status", "finalize"}
+
+// parseResourcePath parses paths like /api/v1/namespaces/{namespace}/pods/{name} or /apis/{group}/{version}/{resource}.
+// Paths of subresources aren't supported.
+func parseResourcePath(requestPath string) (resourceTarget, bool) {
+	segments := strings.Split(strings.Trim(path.Clean("/"+requestPath), "/"), "/")
+
+	var target resourceTarget
+	var rest []string
+	switch {
+	case segments[0] == "api" && len(segments) >= 3:
+		target.gvr.Version = segments[1]
+		rest = segments[2:]
+	case segments[0] == "apis" && len(segments) >= 4:
+		target.gvr.Group, target.gvr.Version = segments[1], segments[2]
+		rest = segments[3:]
+	default:
+		return resourceTarget{}, false
+	}
+
+	if rest[0] == "namespaces" && len(rest) >= 3 {
+		if len(rest) == 3 && slices.Contains(namespaceSubresources, rest[2]) {
+			return resourceTarget{}, false
+		}
+		target.namespace = rest[1]
+		rest = rest[2:]
+	}
+	if len(rest) > 2 {
+		return resourceTarget{}, false
+	}
+	target.gvr.Resource = rest[0]
+	if len(rest) == 2 {
+		target.name = rest[1]
+	}
+	return target, true
+}
+
+// informerKube is a Kube which serves requests from an InformerCache if possible.
+var _ Kube = informerKube{}
+
+type informerKube struct {
+	downstream    Kube
+	informers     *InformerCache
+	serviceConfig KubeConfig
+}
+
+// WithInformers returns a Kube which answers the requests it can from the informers, running with the service kubeconfig,
+// and sends the other requests to the downstream Kube. If informers is nil, the downstream Kube is returned.
+func WithInformers(downstream Kube, informers *InformerCache, serviceConfig KubeConfig) Kube {
+	if informers == nil {
+		return downstream
+	}
+	return informerKube{
+		downstream:    downstream,
+		informers:     informers,
+		serviceConfig: serviceConfig,
+	}
+}
+
+func (i informerKube) RequestApiServerRaw(ctx context.Context, request Request, config KubeConfig) (*http.Response, error) {
+	if res, ok := i.informers.Lookup(ctx, request, i.serviceConfig, config); ok {
+		return res, nil
+	}
+	return i.downstream.RequestApiServerRaw(ctx, request, config)
+}
+
+func (i informerKube) RequestApiGroupsByCategory(ctx context.Context, config KubeConfig, category string) ([]v2beta1.APIGroupDiscovery, error) {
+	return i.downstream.RequestApiGroupsByCategory(ctx, config, category)
+}
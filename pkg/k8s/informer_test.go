@@ -0,0 +1,131 @@
+package k8s
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestParseResourcePath(t *testing.T) {
+	tests := []struct {
+		path   string
+		target resourceTarget
+		ok     bool
+	}{
+		{"/api/v1/pods", resourceTarget{gvr: schema.GroupVersionResource{Version: "v1", Resource: "pods"}}, true},
+		{"/api/v1/namespaces/default/pods/a", resourceTarget{gvr: schema.GroupVersionResource{Version: "v1", Resource: "pods"}, namespace: "default", name: "a"}, true},
+		{"/api/v1/namespaces/default", resourceTarget{gvr: schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}, name: "default"}, true},
+		{"/apis/core.openmcp.cloud/v1alpha1/namespaces/project-a/managedcontrolplanes", resourceTarget{gvr: schema.GroupVersionResource{Group: "core.openmcp.cloud", Version: "v1alpha1", Resource: "managedcontrolplanes"}, namespace: "project-a"}, true},
+		{"/api/v1/namespaces/default/pods/a/log", resourceTarget{}, false},
+		{"/api/v1/namespaces/foo/status", resourceTarget{}, false},
+		{"/api/v1/namespaces/foo/finalize", resourceTarget{}, false},
+		{"/apis/apps/v1", resourceTarget{}, false},
+		{"/version", resourceTarget{}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			target, ok := parseResourcePath(test.path)
+			if ok != test.ok || target != test.target {
+				t.Errorf("expected %+v (%v) but got %+v (%v)", test.target, test.ok, target, ok)
+			}
+		})
+	}
+}
+
+func TestInformerCacheServes(t *testing.T) {
+	c := &InformerCache{policy: InformerPolicy{Resources: []string{"managedcontrolplanes.core.openmcp.cloud", "*.*.upbound.io", "pods"}}}
+
+	tests := []struct {
+		gvr    schema.GroupVersionResource
+		serves bool
+	}{
+		{schema.GroupVersionResource{Group: "core.openmcp.cloud", Version: "v1alpha1", Resource: "managedcontrolplanes"}, true},
+		{schema.GroupVersionResource{Group: "s3.aws.upbound.io", Version: "v1beta1", Resource: "buckets"}, true},
+		{schema.GroupVersionResource{Version: "v1", Resource: "pods"}, true},
+		{schema.GroupVersionResource{Version: "v1", Resource: "secrets"}, false},
+		{schema.GroupVersionResource{Group: "upbound.io", Version: "v1", Resource: "providers"}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.gvr.String(), func(t *testing.T) {
+			if serves := c.serves(test.gvr); serves != test.serves {
+				t.Errorf("expected %v but got %v", test.serves, serves)
+			}
+		})
+	}
+}
+
+func TestResourceInformerList(t *testing.T) {
+	informer := &resourceInformer{
+		informer:   cache.NewSharedIndexInformer(&cache.ListWatch{}, &unstructured.Unstructured{}, 0, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}),
+		apiVersion: "v1",
+		listKind:   "PodList",
+	}
+	for _, pod := range []struct{ namespace, name, app string }{{"b", "z", "x"}, {"a", "y", "x"}, {"a", "x", "x"}, {"a", "w", "other"}} {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion("v1")
+		obj.SetKind("Pod")
+		obj.SetNamespace(pod.namespace)
+		obj.SetName(pod.name)
+		obj.SetLabels(map[string]string{"app": pod.app})
+		if err := informer.informer.GetIndexer().Add(obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		namespace string
+		selector  string
+		items     []string
+	}{
+		{"all namespaces", "", "", []string{"a/w", "a/x", "a/y", "b/z"}},
+		{"namespace", "a", "", []string{"a/w", "a/x", "a/y"}},
+		{"label selector", "", "app=x", []string{"a/x", "a/y", "b/z"}},
+		{"empty", "c", "", []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			selector, err := labels.Parse(test.selector)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, ok := informer.list(resourceTarget{namespace: test.namespace}, selector)
+			if !ok || res.StatusCode != http.StatusOK {
+				t.Fatalf("expected list to be served")
+			}
+			body, _ := io.ReadAll(res.Body)
+
+			var list struct {
+				Kind  string                   `json:"kind"`
+				Items []map[string]interface{} `json:"items"`
+			}
+			if err := json.Unmarshal(body, &list); err != nil {
+				t.Fatal(err)
+			}
+			if list.Kind != "PodList" {
+				t.Errorf("expected kind PodList but got %s", list.Kind)
+			}
+			items := []string{}
+			for _, item := range list.Items {
+				items = append(items, objectKey(item))
+			}
+			if len(items) != len(test.items) {
+				t.Fatalf("expected %v but got %v", test.items, items)
+			}
+			for i := range items {
+				if items[i] != test.items[i] {
+					t.Errorf("expected %v but got %v", test.items, items)
+				}
+			}
+		})
+	}
+}
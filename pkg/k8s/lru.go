@@ -0,0 +1,131 @@
+package k8s
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// lruCache is a cache with a memory budget. Every entry has its own expiration, and when the budget is exceeded,
+// the least recently used entries are evicted.
+type lruCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	bytes    int64
+	entries  map[string]*list.Element
+	// order contains the entries, the most recently used one at the front
+	order *list.List
+}
+
+type lruEntry struct {
+	key     string
+	value   any
+	size    int64
+	created time.Time
+	expires time.Time
+	// info describes the entry for the admin endpoints
+	info cacheEntryInfo
+}
+
+func newLRUCache(maxBytes int64) *lruCache {
+	return &lruCache{
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Get returns the value of the key, unless it is missing or expired.
+func (c *lruCache) Get(key string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*lruEntry)
+	if time.Now().After(entry.expires) {
+		c.remove(element)
+		return nil, false
+	}
+
+	c.order.MoveToFront(element)
+	return entry.value, true
+}
+
+// Set stores the value with its approximate size in bytes for the given time. Values which are larger than the
+// whole budget or have no time to live aren't stored and false is returned.
+func (c *lruCache) Set(key string, value any, size int64, ttl time.Duration, info cacheEntryInfo) bool {
+	if ttl <= 0 || size > c.maxBytes {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+
+	now := time.Now()
+	c.entries[key] = c.order.PushFront(&lruEntry{
+		key:     key,
+		value:   value,
+		size:    size,
+		created: now,
+		expires: now.Add(ttl),
+		info:    info,
+	})
+	c.bytes += size
+
+	for c.bytes > c.maxBytes {
+		c.remove(c.order.Back())
+	}
+	return true
+}
+
+// Len returns the number of entries and their total size in bytes, including expired entries which weren't evicted yet.
+func (c *lruCache) Len() (int, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.entries), c.bytes
+}
+
+// Each calls f for every entry which isn't expired, from the most to the least recently used one.
+func (c *lruCache) Each(f func(entry lruEntry)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for element := c.order.Front(); element != nil; element = element.Next() {
+		if entry := element.Value.(*lruEntry); now.Before(entry.expires) {
+			f(*entry)
+		}
+	}
+}
+
+// RemoveFunc removes the entries for which remove returns true and returns how many were removed.
+func (c *lruCache) RemoveFunc(remove func(entry lruEntry) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+	for element := c.order.Front(); element != nil; {
+		next := element.Next()
+		if remove(*element.Value.(*lruEntry)) {
+			c.remove(element)
+			removed++
+		}
+		element = next
+	}
+	return removed
+}
+
+func (c *lruCache) remove(element *list.Element) {
+	entry := element.Value.(*lruEntry)
+	c.order.Remove(element)
+	delete(c.entries, entry.key)
+	c.bytes -= entry.size
+}
@@ -0,0 +1,64 @@
+package k8s
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// upstreamRequestDuration measures the time until the api servers returned the response headers.
+var upstreamRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "ui_backend_upstream_request_duration_seconds",
+	Help:    "Duration of requests to the api servers until the response headers were received.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"method", "code"})
+
+// observeUpstreamRequest records an upstream request, failed requests without a response get the code "error".
+func observeUpstreamRequest(method string, statusCode int, seconds float64) {
+	code := "error"
+	if statusCode > 0 {
+		code = strconv.Itoa(statusCode)
+	}
+	if method == "" {
+		method = "GET"
+	}
+	upstreamRequestDuration.WithLabelValues(strings.ToUpper(method), code).Observe(seconds)
+}
+
+var (
+	cacheRequestsDesc = prometheus.NewDesc("ui_backend_cache_requests_total",
+		"Requests to the caches by result (hit, miss or coalesced).", []string{"cache", "class", "result"}, nil)
+	cacheEntriesDesc = prometheus.NewDesc("ui_backend_cache_entries",
+		"Number of cached responses.", []string{"cache", "class"}, nil)
+	cacheBytesDesc = prometheus.NewDesc("ui_backend_cache_bytes",
+		"Approximate memory used by the cached responses.", []string{"cache", "class"}, nil)
+)
+
+type cacheCollector struct {
+	caches map[string]CacheStatsProvider
+}
+
+// NewCacheCollector exports the stats of the caches, keyed by their name, as prometheus metrics.
+func NewCacheCollector(caches map[string]CacheStatsProvider) prometheus.Collector {
+	return cacheCollector{caches: caches}
+}
+
+func (c cacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cacheRequestsDesc
+	ch <- cacheEntriesDesc
+	ch <- cacheBytesDesc
+}
+
+func (c cacheCollector) Collect(ch chan<- prometheus.Metric) {
+	for name, cache := range c.caches {
+		for class, stats := range cache.Stats().Classes {
+			ch <- prometheus.MustNewConstMetric(cacheRequestsDesc, prometheus.CounterValue, float64(stats.Hits), name, class, "hit")
+			ch <- prometheus.MustNewConstMetric(cacheRequestsDesc, prometheus.CounterValue, float64(stats.Misses), name, class, "miss")
+			ch <- prometheus.MustNewConstMetric(cacheRequestsDesc, prometheus.CounterValue, float64(stats.Coalesced), name, class, "coalesced")
+			ch <- prometheus.MustNewConstMetric(cacheEntriesDesc, prometheus.GaugeValue, float64(stats.Entries), name, class)
+			ch <- prometheus.MustNewConstMetric(cacheBytesDesc, prometheus.GaugeValue, float64(stats.Bytes), name, class)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package k8s
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type fakeStatsProvider CacheStats
+
+func (f fakeStatsProvider) Stats() CacheStats {
+	return CacheStats(f)
+}
+
+func TestCacheCollector(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(NewCacheCollector(map[string]CacheStatsProvider{
+		"crate": fakeStatsProvider{Classes: map[string]CacheStats{
+			cacheClassSecrets: {Hits: 3, Misses: 1, Entries: 1, Bytes: 100},
+		}},
+	}))
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]float64{}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			name := family.GetName()
+			for _, label := range metric.GetLabel() {
+				name += "," + label.GetValue()
+			}
+			values[name] = metric.GetCounter().GetValue() + metric.GetGauge().GetValue()
+		}
+	}
+
+	expected := map[string]float64{
+		"ui_backend_cache_requests_total,crate,secrets,hit":       3,
+		"ui_backend_cache_requests_total,crate,secrets,miss":      1,
+		"ui_backend_cache_requests_total,crate,secrets,coalesced": 0,
+		"ui_backend_cache_entries,crate,secrets":                  1,
+		"ui_backend_cache_bytes,crate,secrets":                    100,
+	}
+	for name, value := range expected {
+		if got, ok := values[name]; !ok || got != value {
+			t.Errorf("expected %s to be %v but got %v", name, value, got)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"k8s.io/api/apidiscovery/v2beta1"
+)
+
+var _ Kube = retryingKube{}
+
+// RetryPolicy configures how often and how fast failed requests are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts including the first one, values below 2 disable retries
+	MaxAttempts int
+	// InitialBackoff is the wait time before the first retry, it doubles with every further retry
+	InitialBackoff time.Duration
+	// MaxBackoff limits the wait time between two attempts
+	MaxBackoff time.Duration
+}
+
+// retryableStatusCodes are the responses which indicate a transient problem of the api server or a proxy in front of it.
+var retryableStatusCodes = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+type retryingKube struct {
+	downstream Kube
+	policy     RetryPolicy
+}
+
+// NewRetryingKube retries requests with safe methods (GET and HEAD) which failed with a connection error (refused,
+// reset, timed out or closed early) or a 502, 503 or 504 response, waiting with exponential backoff between the attempts.
+func NewRetryingKube(downstream Kube, policy RetryPolicy) Kube {
+	return retryingKube{
+		downstream: downstream,
+		policy:     policy,
+	}
+}
+
+func (r retryingKube) RequestApiServerRaw(ctx context.Context, request Request, config KubeConfig) (*http.Response, error) {
+	method := strings.ToUpper(request.Method)
+	retryable := (method == "" || method == http.MethodGet || method == http.MethodHead) && !request.hasBody()
+
+	backoff := r.policy.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		res, err := r.downstream.RequestApiServerRaw(ctx, request, config)
+		if !retryable || attempt >= r.policy.MaxAttempts || ctx.Err() != nil || !isTransient(res, err) {
+			return res, err
+		}
+
+		if err != nil {
+			slog.Debug("retrying failed request", "path", request.Path, "attempt", attempt, "err", err)
+		} else {
+			slog.Debug("retrying failed request", "path", request.Path, "attempt", attempt, "status", res.StatusCode)
+			_, _ = io.Copy(io.Discard, res.Body)
+			_ = res.Body.Close()
+		}
+
+		// the jitter spreads the retries of concurrent requests
+		timer := time.NewTimer(backoff/2 + rand.N(backoff/2+1))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("retrying request aborted: %w", context.Cause(ctx))
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, r.policy.MaxBackoff)
+	}
+}
+
+func (r retryingKube) RequestApiGroupsByCategory(ctx context.Context, config KubeConfig, category string) ([]v2beta1.APIGroupDiscovery, error) {
+	return DiscoverResources(ctx, r, config, ByCategory(category))
+}
+
+// isTransient returns whether the request failed in a way which might succeed when it is retried. Only connection
+// problems are retried, other errors like invalid certificates or canceled requests would fail again.
+func isTransient(res *http.Response, err error) bool {
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return true
+		}
+		return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrUnexpectedEOF)
+	}
+	return slices.Contains(retryableStatusCodes, res.StatusCode)
+}
@@ -0,0 +1,95 @@
+package k8s
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"k8s.io/api/apidiscovery/v2beta1"
+)
+
+// flakyKube fails with the given results before answering with 200.
+type flakyKube struct {
+	failures []error
+	statuses []int
+	requests int
+}
+
+func (f *flakyKube) RequestApiServerRaw(_ context.Context, _ Request, _ KubeConfig) (*http.Response, error) {
+	f.requests++
+	if f.requests <= len(f.failures) && f.failures[f.requests-1] != nil {
+		return nil, f.failures[f.requests-1]
+	}
+	statusCode := http.StatusOK
+	if f.requests <= len(f.statuses) {
+		statusCode = f.statuses[f.requests-1]
+	}
+	return &http.Response{StatusCode: statusCode, Body: io.NopCloser(strings.NewReader("{}"))}, nil
+}
+
+func (f *flakyKube) RequestApiGroupsByCategory(_ context.Context, _ KubeConfig, _ string) ([]v2beta1.APIGroupDiscovery, error) {
+	return nil, nil
+}
+
+func TestRetryingKube(t *testing.T) {
+	urlErr := func(err error) error {
+		return &url.Error{Op: "Get", URL: "https://a", Err: err}
+	}
+	connectionErr := urlErr(&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)})
+	resetErr := urlErr(&net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)})
+	timeoutErr := urlErr(&net.DNSError{Err: "i/o timeout", Name: "a", IsTimeout: true})
+	certificateErr := urlErr(&tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}})
+
+	tests := []struct {
+		name       string
+		method     string
+		failures   []error
+		statuses   []int
+		requests   int
+		statusCode int
+		err        bool
+	}{
+		{"success", "GET", nil, nil, 1, http.StatusOK, false},
+		{"connection error", "GET", []error{connectionErr}, nil, 2, http.StatusOK, false},
+		{"service unavailable", "GET", nil, []int{http.StatusServiceUnavailable, http.StatusBadGateway}, 3, http.StatusOK, false},
+		{"gives up", "GET", []error{connectionErr, connectionErr, connectionErr}, nil, 3, 0, true},
+		{"not found isn't retried", "GET", nil, []int{http.StatusNotFound}, 1, http.StatusNotFound, false},
+		{"other errors aren't retried", "GET", []error{errors.New("invalid kubeconfig")}, nil, 1, 0, true},
+		{"connection reset", "GET", []error{resetErr}, nil, 2, http.StatusOK, false},
+		{"timeout", "GET", []error{timeoutErr}, nil, 2, http.StatusOK, false},
+		{"unexpected EOF", "GET", []error{urlErr(io.ErrUnexpectedEOF)}, nil, 2, http.StatusOK, false},
+		{"certificate errors aren't retried", "GET", []error{certificateErr}, nil, 1, 0, true},
+		{"canceled requests aren't retried", "GET", []error{urlErr(context.Canceled)}, nil, 1, 0, true},
+		{"upstream timeouts aren't retried", "GET", []error{fmt.Errorf("failed to request api server: %w", ErrUpstreamTimeout)}, nil, 1, 0, true},
+		{"post isn't retried", "POST", []error{connectionErr}, nil, 1, 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			downstream := &flakyKube{failures: test.failures, statuses: test.statuses}
+			kube := NewRetryingKube(downstream, RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+
+			res, err := kube.RequestApiServerRaw(context.Background(), Request{Method: test.method, Path: "/api"}, KubeConfig{})
+			if (err != nil) != test.err {
+				t.Fatalf("expected error to be %v but got: %v", test.err, err)
+			}
+			if err == nil && res.StatusCode != test.statusCode {
+				t.Errorf("expected status %d but got %d", test.statusCode, res.StatusCode)
+			}
+			if downstream.requests != test.requests {
+				t.Errorf("expected %d requests but got %d", test.requests, downstream.requests)
+			}
+		})
+	}
+}
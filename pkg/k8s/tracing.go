@@ -0,0 +1,65 @@
+package k8s
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/api/apidiscovery/v2beta1"
+)
+
+var tracer = otel.Tracer("github.com/openmcp-project/ui-backend/pkg/k8s")
+
+// traceHeaders are set by the propagator, they differ for every request and must not be part of cache keys.
+var traceHeaders = []string{"Traceparent", "Tracestate", "Baggage"}
+
+var _ Kube = tracingKube{}
+
+type tracingKube struct {
+	downstream Kube
+}
+
+// NewTracingKube records a span as child of the span in the context for every request. The trace context is propagated
+// to the api servers by HttpKube.
+func NewTracingKube(downstream Kube) Kube {
+	return tracingKube{downstream: downstream}
+}
+
+func (t tracingKube) RequestApiServerRaw(ctx context.Context, request Request, config KubeConfig) (*http.Response, error) {
+	ctx, span := tracer.Start(ctx, "kube.RequestApiServerRaw", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("http.request.method", request.Method),
+		attribute.String("server.address", clusterServer(config)),
+		attribute.String("url.path", request.Path),
+	))
+	defer span.End()
+
+	res, err := t.downstream.RequestApiServerRaw(ctx, request, config)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("http.response.status_code", res.StatusCode))
+	if res.StatusCode >= 500 {
+		span.SetStatus(codes.Error, res.Status)
+	}
+	return res, nil
+}
+
+func (t tracingKube) RequestApiGroupsByCategory(ctx context.Context, config KubeConfig, category string) ([]v2beta1.APIGroupDiscovery, error) {
+	ctx, span := tracer.Start(ctx, "kube.RequestApiGroupsByCategory", trace.WithAttributes(
+		attribute.String("server.address", clusterServer(config)),
+		attribute.String("category", category),
+	))
+	defer span.End()
+
+	groups, err := t.downstream.RequestApiGroupsByCategory(ctx, config, category)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return groups, err
+}
@@ -0,0 +1,54 @@
+package k8s
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracingKube(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("Traceparent")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"items":[]}`))
+	}))
+	defer server.Close()
+	config, err := ParseKubeconfig("clusters:\n- cluster:\n    server: " + server.URL + "\nusers:\n- user:\n    token: token1\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "handler")
+	res, err := NewTracingKube(HttpKube{}).RequestApiServerRaw(ctx, Request{Method: "GET", Path: "/api/v1/namespaces"}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	parent.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 || spans[0].Name != "kube.RequestApiServerRaw" {
+		t.Fatalf("expected the kube span and its parent but got %v", spans.Snapshots())
+	}
+	span := spans[0]
+	if span.Parent.SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("expected the kube span to be a child of the handler span")
+	}
+
+	expected := "00-" + span.SpanContext.TraceID().String() + "-" + span.SpanContext.SpanID().String() + "-01"
+	if traceparent != expected {
+		t.Errorf("expected traceparent %s but got %s", expected, traceparent)
+	}
+}
@@ -0,0 +1,124 @@
+package k8s
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// transportMaxIdleConnsPerHost limits the idle HTTP/1.1 connections kept per cluster. With HTTP/2 a single
+	// connection is multiplexed anyway.
+	transportMaxIdleConnsPerHost = 10
+	transportIdleConnTimeout     = 90 * time.Second
+	// transportIdleTimeout is the time after which an unused transport is removed from the pool.
+	transportIdleTimeout = 10 * time.Minute
+	// transportPoolSize limits the number of pooled transports, the least recently used one is evicted beyond it.
+	transportPoolSize = 256
+)
+
+// transportPool reuses HTTP transports per cluster, so connections and TLS sessions are kept alive between requests.
+// Transports are keyed by the server and a fingerprint of the CA and client certificate, so users with different client
+// certificates for the same server each have their own transport. Transports are evicted when they weren't used for
+// transportIdleTimeout or when the pool exceeds its size.
+type transportPool struct {
+	mu         sync.Mutex
+	size       int
+	transports map[string]*pooledTransport
+}
+
+type pooledTransport struct {
+	server    string
+	transport *http.Transport
+	lastUsed  time.Time
+}
+
+var defaultTransportPool = newTransportPool()
+
+func newTransportPool() *transportPool {
+	return &transportPool{
+		size:       transportPoolSize,
+		transports: make(map[string]*pooledTransport),
+	}
+}
+
+// get returns the transport for the first cluster and user of the kubeconfig, creating it if necessary.
+func (p *transportPool) get(config KubeConfig) (*http.Transport, error) {
+	key := transportKey(config)
+	server := config.Clusters[0].Cluster.Server
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if pooled, ok := p.transports[key]; ok {
+		pooled.lastUsed = now
+		return pooled.transport, nil
+	}
+
+	tlsConfig, err := NewTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	p.evict(now)
+
+	transport := &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:     tlsConfig,
+		ForceAttemptHTTP2:   true,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: transportMaxIdleConnsPerHost,
+		IdleConnTimeout:     transportIdleConnTimeout,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	p.transports[key] = &pooledTransport{
+		server:    server,
+		transport: transport,
+		lastUsed:  now,
+	}
+
+	return transport, nil
+}
+
+// evict removes the idle transports and, if the pool is still full, the least recently used one.
+func (p *transportPool) evict(now time.Time) {
+	var leastRecentlyUsed string
+	for k, pooled := range p.transports {
+		if now.Sub(pooled.lastUsed) > transportIdleTimeout {
+			p.remove(k)
+		} else if leastRecentlyUsed == "" || pooled.lastUsed.Before(p.transports[leastRecentlyUsed].lastUsed) {
+			leastRecentlyUsed = k
+		}
+	}
+	if len(p.transports) >= p.size && leastRecentlyUsed != "" {
+		p.remove(leastRecentlyUsed)
+	}
+}
+
+func (p *transportPool) remove(key string) {
+	pooled := p.transports[key]
+	slog.Debug("evicting transport", "host", pooled.server)
+	pooled.transport.CloseIdleConnections()
+	delete(p.transports, key)
+}
+
+// transportKey identifies the TLS relevant parts of a kubeconfig. The token isn't part of it, as it is sent per request.
+func transportKey(config KubeConfig) string {
+	cluster := config.Clusters[0].Cluster
+	user := config.Users[0].User
+
+	h := sha256.New()
+	for _, part := range []string{cluster.Server, cluster.CertificateAuthorityData, user.ClientCertificateData, user.ClientKeyData} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,67 @@
+package k8s
+
+import (
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTransportPool(t *testing.T) {
+	server := httptest.NewTLSServer(nil)
+	defer server.Close()
+	ca := base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+
+	kubeconfig := func(server, ca, token string) KubeConfig {
+		config, err := ParseKubeconfig(fmt.Sprintf("clusters:\n- cluster:\n    server: %s\n    certificate-authority-data: %q\nusers:\n- user:\n    token: %s\n", server, ca, token))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return config
+	}
+
+	pool := newTransportPool()
+	first, err := pool.get(kubeconfig("https://a", "", "token1"))
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+
+	second, _ := pool.get(kubeconfig("https://a", "", "token2"))
+	if first != second {
+		t.Errorf("expected the transport to be reused for another token")
+	}
+
+	other, _ := pool.get(kubeconfig("https://b", "", "token1"))
+	if first == other {
+		t.Errorf("expected another transport for another server")
+	}
+
+	changed, _ := pool.get(kubeconfig("https://a", ca, "token1"))
+	if first == changed {
+		t.Errorf("expected another transport for a changed CA")
+	}
+	if again, _ := pool.get(kubeconfig("https://a", "", "token1")); first != again {
+		t.Errorf("expected the transport of another CA for the same server to be kept")
+	}
+
+	pool.size = 3
+	pool.transports[transportKey(kubeconfig("https://b", "", "token1"))].lastUsed = time.Now().Add(-time.Minute)
+	if _, err := pool.get(kubeconfig("https://d", "", "token1")); err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	if _, ok := pool.transports[transportKey(kubeconfig("https://b", "", "token1"))]; ok || len(pool.transports) != 3 {
+		t.Errorf("expected the least recently used transport to be evicted from the full pool, got %d transports", len(pool.transports))
+	}
+
+	pool.transports[transportKey(kubeconfig("https://d", "", "token1"))].lastUsed = time.Now().Add(-2 * transportIdleTimeout)
+	_, _ = pool.get(kubeconfig("https://e", "", "token1"))
+	if _, ok := pool.transports[transportKey(kubeconfig("https://d", "", "token1"))]; ok {
+		t.Errorf("expected the idle transport to be evicted")
+	}
+
+	if _, err := pool.get(kubeconfig("https://c", "invalid", "token1")); err == nil {
+		t.Errorf("expected an error for invalid CA data but got none")
+	}
+}
@@ -1,20 +1,21 @@
 package openmcp
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 
 	"github.com/openmcp-project/ui-backend/pkg/k8s"
 )
 
-func GetControlPlaneKubeconfig(kube k8s.Kube, projectName, workspaceName, controlPlaneName, crateToken string, crateKubeconfig k8s.KubeConfig) (k8s.KubeConfig, error) {
+func GetControlPlaneKubeconfig(ctx context.Context, kube k8s.Kube, projectName, workspaceName, controlPlaneName, crateToken string, crateKubeconfig k8s.KubeConfig) (k8s.KubeConfig, error) {
 	path := fmt.Sprintf("/apis/core.openmcp.cloud/v1alpha1/namespaces/project-%s--ws-%s/managedcontrolplanes/%s", projectName, workspaceName, controlPlaneName)
 
 	cp := ControlPlane{}
 
 	crateKubeconfig.SetUserToken(crateToken)
 
-	err := k8s.RequestApiServer(kube, k8s.Request{
+	err := k8s.RequestApiServer(ctx, kube, k8s.Request{
 		Method: "GET",
 		Path:   path,
 	}, crateKubeconfig, &cp)
@@ -27,7 +28,7 @@ func GetControlPlaneKubeconfig(kube k8s.Kube, projectName, workspaceName, contro
 
 	secret := k8s.Secret{}
 	path = fmt.Sprintf("api/v1/namespaces/%s/secrets/%s", cp.Status.Components.Authentication.Access.Namespace, cp.Status.Components.Authentication.Access.Name)
-	err = k8s.RequestApiServer(kube, k8s.Request{
+	err = k8s.RequestApiServer(ctx, kube, k8s.Request{
 		Method: "GET",
 		Path:   path,
 	}, crateKubeconfig, &secret)